@@ -0,0 +1,288 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Audit outcome.
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// Events recorded by audit if no types given.
+var DefaultAuditEvents = []EventType{
+	EventTokenIssued,
+	EventTokenRejected,
+	EventTokenDeleted,
+	EventTokenEvicted,
+	EventAudienceSaved,
+	EventAudienceDeleted,
+	EventAudienceStatus,
+}
+
+// Audit record of one security-relevant event.
+// Never contains the raw token string, only its fingerprint.
+type AuditRecord struct {
+	Time        time.Time `json:"time"`
+	Event       EventType `json:"event"`
+	ClientID    string    `json:"client_id,omitempty"`
+	SingleID    string    `json:"single_id,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Outcome     string    `json:"outcome"`
+	Code        string    `json:"code,omitempty"`
+}
+
+// Audit sink interface, records are appended to sink.
+type AuditSink interface {
+
+	// Append record.
+	Write(record *AuditRecord) error
+
+	// Close sink.
+	Close() error
+}
+
+// Called when write audit record fail.
+// Default writes log.
+var AuditErrorHandler = func(record *AuditRecord, err error) {
+	log.Printf("tokenauth: write audit record %s fail: %v", record.Event, err)
+}
+
+// Returns fingerprint of token string, safe to write into logs.
+func TokenFingerprint(tokenString string) string {
+	if len(tokenString) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(tokenString))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Returns audit record of event.
+func NewAuditRecord(event *Event) *AuditRecord {
+	r := &AuditRecord{
+		Time:        event.Time,
+		Event:       event.Type,
+		ClientID:    event.ClientID,
+		Fingerprint: TokenFingerprint(event.TokenValue),
+		Outcome:     AuditSuccess,
+		Code:        event.Code,
+	}
+	if event.Token != nil {
+		r.SingleID = event.Token.SingleID
+		if len(r.ClientID) == 0 {
+			r.ClientID = event.Token.ClientID
+		}
+	}
+	if event.Audience != nil && len(r.ClientID) == 0 {
+		r.ClientID = event.Audience.ID
+	}
+	if event.Err != nil {
+		r.Outcome = AuditFailure
+	}
+	return r
+}
+
+// Write audit records of events of types into sink.
+// Use DefaultAuditEvents if types is empty.
+// Returns func to stop audit, sink is not closed.
+func EnableAudit(sink AuditSink, types ...EventType) (disable func()) {
+	if sink == nil {
+		panic("tokenauth: audit sink is nil")
+	}
+	if len(types) == 0 {
+		types = DefaultAuditEvents
+	}
+	return AddHook(func(event *Event) {
+		record := NewAuditRecord(event)
+		if err := sink.Write(record); err != nil && AuditErrorHandler != nil {
+			AuditErrorHandler(record, err)
+		}
+	}, types...)
+}
+
+// Audit sink writes JSON lines into file.
+// File is rotated when size exceed MaxSize, keep MaxBackups old files
+// named path.1, path.2 ...
+type JSONFileAuditSink struct {
+	Path       string
+	MaxSize    int64 // bytes, 0 is never rotate.
+	MaxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Open or create JSON lines audit file.
+func NewJSONFileAuditSink(path string, maxSize int64, maxBackups int) (*JSONFileAuditSink, error) {
+	s := &JSONFileAuditSink{Path: path, MaxSize: maxSize, MaxBackups: maxBackups}
+	if err := s.openFile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *JSONFileAuditSink) openFile() error {
+	f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file, s.size = f, info.Size()
+	return nil
+}
+
+// Append record as one JSON line.
+func (s *JSONFileAuditSink) Write(record *AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return fmt.Errorf("tokenauth: audit file %s is closed", s.Path)
+	}
+	if s.MaxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.MaxSize {
+		if err = s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// Rotate file, must hold lock.
+func (s *JSONFileAuditSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+
+	if s.MaxBackups <= 0 {
+		if err := os.Remove(s.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		for i := s.MaxBackups - 1; i > 0; i-- {
+			old := fmt.Sprintf("%s.%d", s.Path, i)
+			if _, err := os.Stat(old); err == nil {
+				if err = os.Rename(old, fmt.Sprintf("%s.%d", s.Path, i+1)); err != nil {
+					return err
+				}
+			}
+		}
+		if err := os.Rename(s.Path, s.Path+".1"); err != nil {
+			return err
+		}
+	}
+	return s.openFile()
+}
+
+// Close file.
+func (s *JSONFileAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// audit records save in this buckert, key is big-endian unix nano + sequence.
+var buckert_auditlog = []byte("bk_audit_log")
+
+// Audit sink saves records into bolt db of BoltDBFileStore,
+// records can be queried by time range.
+type BoltAuditSink struct {
+	store *BoltDBFileStore
+	seq   uint32
+}
+
+// New audit sink use db of opened store.
+// Sink does not own db, Close does not close store.
+func NewBoltAuditSink(store *BoltDBFileStore) *BoltAuditSink {
+	if store == nil {
+		panic("tokenauth: audit store is nil")
+	}
+	return &BoltAuditSink{store: store}
+}
+
+func auditKey(t time.Time, seq uint32) []byte {
+	key := make([]byte, 12)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint32(key[8:], seq)
+	return key
+}
+
+// Save record.
+func (s *BoltAuditSink) Write(record *AuditRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	key := auditKey(record.Time, atomic.AddUint32(&s.seq, 1))
+	return s.store.update(func(tx *bolt.Tx) error {
+		bk, err := tx.CreateBucketIfNotExists(buckert_auditlog)
+		if err != nil {
+			return err
+		}
+		return bk.Put(key, data)
+	})
+}
+
+// Returns records of time range [from,to), order by time.
+func (s *BoltAuditSink) Query(from, to time.Time) ([]*AuditRecord, error) {
+	records := make([]*AuditRecord, 0)
+	min, max := auditKey(from, 0), auditKey(to, 0)
+	err := s.store.view(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(buckert_auditlog)
+		if bk == nil {
+			return nil
+		}
+		c := bk.Cursor()
+		for k, v := c.Seek(min); k != nil && bytes.Compare(k, max) < 0; k, v = c.Next() {
+			r := &AuditRecord{}
+			if err := json.Unmarshal(v, r); err != nil {
+				return err
+			}
+			records = append(records, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Does nothing, db is closed by store.
+func (s *BoltAuditSink) Close() error {
+	return nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/ysqi/tokenauth"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+type memoryAuditSink struct {
+	records []*tokenauth.AuditRecord
+}
+
+func (m *memoryAuditSink) Write(r *tokenauth.AuditRecord) error {
+	m.records = append(m.records, r)
+	return nil
+}
+
+func (m *memoryAuditSink) Close() error { return nil }
+
+func (s *S) TestAudit_Enable(c *C) {
+
+	sink := &memoryAuditSink{}
+	disable := tokenauth.EnableAudit(sink)
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret)
+	token, _ := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+	tokenauth.ValidateToken(token.Value)
+	tokenauth.ValidateToken("guess")
+	tokenauth.DeleteToken(token.Value)
+	disable()
+	tokenauth.ValidateToken("guess")
+
+	c.Assert(len(sink.records), Equals, 4)
+	c.Assert(sink.records[0].Event, Equals, tokenauth.EventAudienceSaved)
+	c.Assert(sink.records[0].ClientID, Equals, audience.ID)
+
+	c.Assert(sink.records[1].Event, Equals, tokenauth.EventTokenIssued)
+	c.Assert(sink.records[1].Fingerprint, Equals, tokenauth.TokenFingerprint(token.Value))
+	c.Assert(sink.records[1].Fingerprint, Not(Equals), token.Value)
+
+	c.Assert(sink.records[2].Event, Equals, tokenauth.EventTokenRejected)
+	c.Assert(sink.records[2].Outcome, Equals, tokenauth.AuditFailure)
+	c.Assert(sink.records[2].Code, Equals, tokenauth.ERR_InvalidateToken.Code)
+
+	c.Assert(sink.records[3].Event, Equals, tokenauth.EventTokenDeleted)
+	c.Assert(sink.records[3].Outcome, Equals, tokenauth.AuditSuccess)
+	c.Assert(sink.records[3].ClientID, Equals, audience.ID)
+	c.Assert(sink.records[3].Fingerprint, Equals, tokenauth.TokenFingerprint(token.Value))
+}
+
+func (s *S) TestAudit_DeleteSingle(c *C) {
+
+	sink := &memoryAuditSink{}
+	disable := tokenauth.EnableAudit(sink, tokenauth.EventTokenDeleted)
+	defer disable()
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret)
+	token, _ := tokenauth.NewSingleToken("user1", audience, keyPorvider.GenerateTokenString)
+	c.Assert(tokenauth.DeleteToken(token.Value), IsNil)
+
+	c.Assert(len(sink.records), Equals, 1)
+	c.Assert(sink.records[0].ClientID, Equals, audience.ID)
+	c.Assert(sink.records[0].SingleID, Equals, "user1")
+}
+
+func (s *S) TestAudit_JSONFileSink(c *C) {
+
+	dir, _ := ioutil.TempDir("", "audit-")
+	defer os.RemoveAll(dir)
+	path := dir + "/audit.log"
+
+	sink, err := tokenauth.NewJSONFileAuditSink(path, 300, 2)
+	c.Assert(err, IsNil)
+	for i := 0; i < 10; i++ {
+		err = sink.Write(&tokenauth.AuditRecord{
+			Time:        time.Now(),
+			Event:       tokenauth.EventTokenIssued,
+			Fingerprint: tokenauth.TokenFingerprint("token"),
+			Outcome:     tokenauth.AuditSuccess,
+		})
+		c.Assert(err, IsNil)
+	}
+	c.Assert(sink.Close(), IsNil)
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		c.Assert(err, IsNil)
+		c.Assert(info.Size() <= 300, Equals, true)
+	}
+	_, err = os.Stat(path + ".3")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	f, _ := os.Open(path)
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		r := &tokenauth.AuditRecord{}
+		c.Assert(json.Unmarshal(scanner.Bytes(), r), IsNil)
+		c.Assert(strings.Contains(scanner.Text(), `"token"`), Equals, false)
+		c.Assert(r.Event, Equals, tokenauth.EventTokenIssued)
+	}
+}
+
+func (s *S) TestAudit_BoltSink(c *C) {
+
+	st := openBoltStore()
+	defer st.Close()
+	sink := tokenauth.NewBoltAuditSink(st)
+
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		sink.Write(&tokenauth.AuditRecord{
+			Time:    start.Add(time.Duration(i) * time.Minute),
+			Event:   tokenauth.EventTokenRejected,
+			Outcome: tokenauth.AuditFailure,
+		})
+	}
+
+	records, err := sink.Query(start.Add(time.Minute), start.Add(3*time.Minute))
+	c.Assert(err, IsNil)
+	c.Assert(len(records), Equals, 2)
+	c.Assert(records[0].Time.Equal(start.Add(time.Minute)), Equals, true)
+	c.Assert(records[1].Time.Equal(start.Add(2*time.Minute)), Equals, true)
+
+	records, err = sink.Query(start, time.Now())
+	c.Assert(err, IsNil)
+	c.Assert(len(records), Equals, 5)
+}
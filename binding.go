@@ -0,0 +1,138 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Binding of token to client attributes, set at issuance.
+// Every non-empty field must match the client attributes on validation.
+type Binding struct {
+	IP             string `json:",omitempty"` // Client IP or CIDR, e.g: "10.0.0.1" or "10.0.0.0/8".
+	CertThumbprint string `json:",omitempty"` // mTLS certificate SHA-256 thumbprint, see RFC 8705 x5t#S256.
+	Fingerprint    string `json:",omitempty"` // Custom client fingerprint.
+	JKT            string `json:",omitempty"` // DPoP public key thumbprint, see RFC 9449.
+}
+
+// Attributes of client which sends token.
+type ClientAttributes struct {
+	IP             string
+	Certificate    *x509.Certificate // Client certificate, used if CertThumbprint is empty.
+	CertThumbprint string
+	Fingerprint    string
+	JKT            string // Thumbprint of verified DPoP proof key.
+}
+
+// Returns RFC 8705 x5t#S256 thumbprint of certificate,
+// base64url encoded SHA-256 hash of DER certificate.
+func CertThumbprint(cert *x509.Certificate) string {
+	if cert == nil {
+		return ""
+	}
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Returns client attributes of http request.
+// IP is taken from RemoteAddr, proxy headers are not trusted.
+func ClientAttributesFromRequest(r *http.Request) *ClientAttributes {
+	attrs := &ClientAttributes{IP: r.RemoteAddr}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		attrs.IP = host
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		attrs.Certificate = r.TLS.PeerCertificates[0]
+	}
+	return attrs
+}
+
+// Returns option to bind token to client attributes.
+func WithBinding(binding Binding) TokenOption {
+	return func(token *Token) {
+		token.Binding = &binding
+	}
+}
+
+// Returns true if attrs match all fields of binding.
+func (b *Binding) Match(attrs *ClientAttributes) bool {
+	if b == nil {
+		return true
+	}
+	if attrs == nil {
+		attrs = &ClientAttributes{}
+	}
+	if len(b.IP) > 0 && !matchIP(b.IP, attrs.IP) {
+		return false
+	}
+	if len(b.CertThumbprint) > 0 {
+		thumbprint := attrs.CertThumbprint
+		if len(thumbprint) == 0 {
+			thumbprint = CertThumbprint(attrs.Certificate)
+		}
+		if !secureEqual(b.CertThumbprint, thumbprint) {
+			return false
+		}
+	}
+	if len(b.Fingerprint) > 0 && !secureEqual(b.Fingerprint, attrs.Fingerprint) {
+		return false
+	}
+	if len(b.JKT) > 0 && !secureEqual(b.JKT, attrs.JKT) {
+		return false
+	}
+	return true
+}
+
+func matchIP(bound, client string) bool {
+	ip := net.ParseIP(client)
+	if ip == nil {
+		return false
+	}
+	if strings.Contains(bound, "/") {
+		_, network, err := net.ParseCIDR(bound)
+		return err == nil && network.Contains(ip)
+	}
+	boundIP := net.ParseIP(bound)
+	return boundIP != nil && boundIP.Equal(ip)
+}
+
+func secureEqual(a, b string) bool {
+	return len(a) > 0 && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// Rejects bound token, used by validations without client attributes.
+func checkUnbound(token *Token) error {
+	if !token.Binding.Match(nil) {
+		return ERR_TokenBindingMismatch
+	}
+	return nil
+}
+
+// Same as ValidateToken and check token binding matches attrs.
+// Returns TokenBindingMismatch error if not match.
+// Token without binding matches any attrs.
+func ValidateBoundToken(tokenString string, attrs *ClientAttributes) (*Token, error) {
+	return ValidateBoundTokenContext(context.Background(), tokenString, attrs)
+}
+
+// Same as ValidateBoundToken, trace span is child of span in ctx.
+func ValidateBoundTokenContext(ctx context.Context, tokenString string, attrs *ClientAttributes) (*Token, error) {
+	if attrs != nil && len(attrs.IP) > 0 && len(clientIP(ctx)) == 0 {
+		ctx = WithClientIP(ctx, attrs.IP)
+	}
+	return validateWith(ctx, tokenString, func(token *Token) error {
+		if !token.Binding.Match(attrs) {
+			return ERR_TokenBindingMismatch
+		}
+		return nil
+	})
+}
@@ -0,0 +1,76 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/ysqi/tokenauth"
+	. "gopkg.in/check.v1"
+	"net/http"
+)
+
+func (s *S) TestBinding_Match(c *C) {
+
+	cert := &x509.Certificate{Raw: []byte("certificate der")}
+	thumbprint := tokenauth.CertThumbprint(cert)
+	c.Assert(len(thumbprint), Equals, 43)
+
+	cases := []struct {
+		binding tokenauth.Binding
+		attrs   *tokenauth.ClientAttributes
+		match   bool
+	}{
+		{tokenauth.Binding{}, nil, true},
+		{tokenauth.Binding{IP: "10.0.0.1"}, &tokenauth.ClientAttributes{IP: "10.0.0.1"}, true},
+		{tokenauth.Binding{IP: "10.0.0.1"}, &tokenauth.ClientAttributes{IP: "10.0.0.2"}, false},
+		{tokenauth.Binding{IP: "10.0.0.0/8"}, &tokenauth.ClientAttributes{IP: "10.1.2.3"}, true},
+		{tokenauth.Binding{IP: "10.0.0.0/8"}, &tokenauth.ClientAttributes{IP: "192.168.0.1"}, false},
+		{tokenauth.Binding{IP: "10.0.0.1"}, nil, false},
+		{tokenauth.Binding{CertThumbprint: thumbprint}, &tokenauth.ClientAttributes{Certificate: cert}, true},
+		{tokenauth.Binding{CertThumbprint: thumbprint}, &tokenauth.ClientAttributes{CertThumbprint: thumbprint}, true},
+		{tokenauth.Binding{CertThumbprint: thumbprint}, &tokenauth.ClientAttributes{}, false},
+		{tokenauth.Binding{Fingerprint: "fp"}, &tokenauth.ClientAttributes{Fingerprint: "fp"}, true},
+		{tokenauth.Binding{Fingerprint: "fp", IP: "10.0.0.1"}, &tokenauth.ClientAttributes{Fingerprint: "fp"}, false},
+	}
+	for i, t := range cases {
+		c.Check(t.binding.Match(t.attrs), Equals, t.match, Commentf("case %d", i))
+	}
+}
+
+func (s *S) TestBinding_Validate(c *C) {
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret)
+	token, err := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString,
+		tokenauth.WithBinding(tokenauth.Binding{IP: "10.0.0.0/8"}))
+	c.Assert(err, IsNil)
+
+	newToken, err := tokenauth.ValidateBoundToken(token.Value, &tokenauth.ClientAttributes{IP: "10.0.0.5"})
+	c.Assert(err, IsNil)
+	c.Assert(newToken, DeepEquals, token)
+
+	newToken, err = tokenauth.ValidateBoundToken(token.Value, &tokenauth.ClientAttributes{IP: "8.8.8.8"})
+	c.Assert(err, Equals, tokenauth.ERR_TokenBindingMismatch)
+	c.Assert(newToken, IsNil)
+
+	// Validation without client attributes rejects bound token.
+	newToken, err = tokenauth.ValidateToken(token.Value)
+	c.Assert(err, Equals, tokenauth.ERR_TokenBindingMismatch)
+	c.Assert(newToken, IsNil)
+	_, err = tokenauth.ValidateTokenFor(token.Value, audience.ID)
+	c.Assert(err, Equals, tokenauth.ERR_TokenBindingMismatch)
+}
+
+func (s *S) TestBinding_Request(c *C) {
+
+	cert := &x509.Certificate{Raw: []byte("certificate der")}
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:5678"
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+
+	attrs := tokenauth.ClientAttributesFromRequest(r)
+	c.Assert(attrs.IP, Equals, "10.0.0.1")
+	c.Assert(attrs.Certificate, Equals, cert)
+}
@@ -5,20 +5,72 @@
 package tokenauth
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
+	"crypto/rand"
+	"encoding/base32"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
-	"time"
+	"math"
+	"math/big"
+	"strings"
 )
 
 const (
 	// default secret length.
 	SecretLength = 32
+	// default random bytes of token.
+	TokenBytes = 32
 )
 
+// Encoding of random token bytes, all encodings are URL safe.
+type Encoding int
+
+const (
+	EncodingBase64URL Encoding = iota // base64url without padding.
+	EncodingBase32                    // base32 without padding.
+	EncodingBase62                    // 0-9a-zA-Z, fixed length.
+	EncodingHex                       // lowercase hex.
+)
+
+// Returns b encoded by enc.
+func (enc Encoding) EncodeToString(b []byte) string {
+	switch enc {
+	case EncodingBase32:
+		return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+	case EncodingBase62:
+		if len(b) == 0 {
+			return ""
+		}
+		// Pad to fixed length of len(b) bytes.
+		size := int(math.Ceil(float64(len(b)) * 8 / math.Log2(62)))
+		s := new(big.Int).SetBytes(b).Text(62)
+		return strings.Repeat("0", size-len(s)) + s
+	case EncodingHex:
+		return hex.EncodeToString(b)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Returns n crypto random bytes encoded by enc.
+// Returns error wraps ErrInvalidArgument if n <= 0.
+func RandomToken(n int, enc Encoding) (string, error) {
+	if n <= 0 {
+		return "", fmt.Errorf("tokenauth: random token bytes %d: %w", n, ErrInvalidArgument)
+	}
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return enc.EncodeToString(b), nil
+}
+
+// Default provider of secret and token string.
+// Token is TokenBytes crypto random bytes encoded by Encoding.
 type DefaultProvider struct {
 	Name string
+
+	TokenBytes int      // Random bytes of token, 0 is TokenBytes.
+	Encoding   Encoding // Encoding of token, default EncodingBase64URL.
 }
 
 func (d *DefaultProvider) GenerateSecretString(clientID string) (secretString string) {
@@ -26,17 +78,13 @@ func (d *DefaultProvider) GenerateSecretString(clientID string) (secretString st
 	return GenerateRandomString(SecretLength, false)
 }
 
+// Returns new random token string, panics if crypto random source fails.
+// Use NewTokenString to get the error.
 func (d *DefaultProvider) GenerateTokenString(audience *Audience) string {
 
-	if audience == nil {
-		panic("audience is nil")
+	token, err := d.NewTokenString(audience)
+	if err != nil {
+		panic(fmt.Sprintf("tokenauth: generate token fail, %s", err.Error()))
 	}
-
-	hash := hmac.New(sha256.New, []byte(audience.Secret))
-
-	info := fmt.Sprintf("%s:%s:%d", audience.ID, GenerateRandomString(6, false), time.Now().Unix())
-	hash.Write([]byte(info))
-
-	return base64.StdEncoding.EncodeToString(hash.Sum(nil))
-
+	return token
 }
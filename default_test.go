@@ -5,8 +5,11 @@
 package tokenauth_test
 
 import (
+	"errors"
 	"github.com/ysqi/tokenauth"
 	. "gopkg.in/check.v1"
+	"regexp"
+	"strings"
 )
 
 func (s *S) TestProvider_NewSecret(c *C) {
@@ -53,3 +56,34 @@ func (s *S) TestProvider_Tokne_New(c *C) {
 	}
 
 }
+
+func (s *S) TestProvider_Encodings(c *C) {
+
+	cases := []struct {
+		enc     tokenauth.Encoding
+		pattern string
+	}{
+		{tokenauth.EncodingBase64URL, `^[0-9A-Za-z_-]{43}$`},
+		{tokenauth.EncodingBase32, `^[A-Z2-7]{52}$`},
+		{tokenauth.EncodingBase62, `^[0-9A-Za-z]{43}$`},
+		{tokenauth.EncodingHex, `^[0-9a-f]{64}$`},
+	}
+	for i, t := range cases {
+		provider := &tokenauth.DefaultProvider{Encoding: t.enc}
+		token := provider.GenerateTokenString(nil)
+		c.Check(regexp.MustCompile(t.pattern).MatchString(token), Equals, true, Commentf("case %d %s", i, token))
+		c.Check(provider.GenerateTokenString(nil), Not(Equals), token)
+	}
+
+	// Base62 keeps fixed length with leading zero bytes.
+	c.Assert(tokenauth.EncodingBase62.EncodeToString(make([]byte, 32)), Equals, strings.Repeat("0", 43))
+	c.Assert(tokenauth.EncodingBase62.EncodeToString(nil), Equals, "")
+
+	for _, n := range []int{0, -1} {
+		_, err := tokenauth.RandomToken(n, tokenauth.EncodingBase62)
+		c.Assert(errors.Is(err, tokenauth.ErrInvalidArgument), Equals, true)
+	}
+
+	provider := &tokenauth.DefaultProvider{TokenBytes: 16, Encoding: tokenauth.EncodingHex}
+	c.Assert(len(provider.GenerateTokenString(nil)), Equals, 32)
+}
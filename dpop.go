@@ -0,0 +1,284 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default max age of DPoP proof.
+const DefaultDPoPMaxAge = 5 * time.Minute
+
+// DPoP proof-of-possession, see RFC 9449.
+// Token is bound to thumbprint of client public key by WithDPoP,
+// every request carries a proof JWT signed by the client private key.
+
+// Returns option to bind token to DPoP public key thumbprint (RFC 7638).
+func WithDPoP(jkt string) TokenOption {
+	return func(token *Token) {
+		if token.Binding == nil {
+			token.Binding = &Binding{}
+		}
+		token.Binding.JKT = jkt
+	}
+}
+
+// Replay cache of DPoP proof jti.
+type ReplayCache interface {
+
+	// Returns true if jti was seen before expires, else remember jti until expires.
+	Seen(jti string, expires time.Time) bool
+}
+
+// In-memory replay cache.
+type MemoryReplayCache struct {
+	mu    sync.Mutex
+	items map[string]time.Time
+	next  time.Time
+}
+
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{items: make(map[string]time.Time)}
+}
+
+func (m *MemoryReplayCache) Seen(jti string, expires time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	// Remove expired items at most once a minute.
+	if now.After(m.next) {
+		for k, e := range m.items {
+			if now.After(e) {
+				delete(m.items, k)
+			}
+		}
+		m.next = now.Add(time.Minute)
+	}
+	if e, ok := m.items[jti]; ok && now.Before(e) {
+		return true
+	}
+	m.items[jti] = expires
+	return false
+}
+
+// Validator of DPoP proof JWT.
+type DPoPValidator struct {
+	MaxAge time.Duration // max age of proof iat, 0 is DefaultDPoPMaxAge.
+	Leeway time.Duration // allowed clock skew of proof iat in the future.
+	Replay ReplayCache
+}
+
+// New validator with in-memory replay cache.
+func NewDPoPValidator() *DPoPValidator {
+	return &DPoPValidator{Leeway: 5 * time.Second, Replay: NewMemoryReplayCache()}
+}
+
+type dpopHeader struct {
+	Typ string          `json:"typ"`
+	Alg string          `json:"alg"`
+	JWK json.RawMessage `json:"jwk"`
+}
+
+type dpopClaims struct {
+	JTI string `json:"jti"`
+	HTM string `json:"htm"`
+	HTU string `json:"htu"`
+	IAT int64  `json:"iat"`
+	ATH string `json:"ath"`
+}
+
+// Verify DPoP proof of request method and url.
+// accessToken is checked with ath claim if not empty.
+// Returns thumbprint of proof public key, or DPoPProofInvalid error.
+func (v *DPoPValidator) VerifyProof(proof, method, rawURL, accessToken string) (jkt string, err error) {
+	parts := strings.Split(proof, ".")
+	if len(parts) != 3 {
+		return "", ERR_DPoPProofInvalid
+	}
+	header := &dpopHeader{}
+	claims := &dpopClaims{}
+	if err = decodeJWTPart(parts[0], header); err != nil {
+		return "", ERR_DPoPProofInvalid
+	}
+	if err = decodeJWTPart(parts[1], claims); err != nil {
+		return "", ERR_DPoPProofInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || header.Typ != "dpop+jwt" {
+		return "", ERR_DPoPProofInvalid
+	}
+
+	key, jkt, err := parseJWK(header.JWK)
+	if err != nil {
+		return "", ERR_DPoPProofInvalid.WithCause(err)
+	}
+	if !verifyJWS(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig) {
+		return "", ERR_DPoPProofInvalid
+	}
+
+	if !strings.EqualFold(claims.HTM, method) || !sameHTU(claims.HTU, rawURL) || len(claims.JTI) == 0 {
+		return "", ERR_DPoPProofInvalid
+	}
+	maxAge := v.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultDPoPMaxAge
+	}
+	now := time.Now()
+	iat := time.Unix(claims.IAT, 0)
+	if iat.Before(now.Add(-maxAge)) || iat.After(now.Add(v.Leeway)) {
+		return "", ERR_DPoPProofInvalid
+	}
+	if len(accessToken) > 0 {
+		sum := sha256.Sum256([]byte(accessToken))
+		if !secureEqual(claims.ATH, base64.RawURLEncoding.EncodeToString(sum[:])) {
+			return "", ERR_DPoPProofInvalid
+		}
+	}
+	if v.Replay != nil && v.Replay.Seen(jkt+":"+claims.JTI, iat.Add(maxAge+v.Leeway)) {
+		return "", ERR_DPoPProofInvalid
+	}
+	return jkt, nil
+}
+
+// Validate token with DPoP proof of request method and url.
+// Token bound by WithDPoP must have a valid proof signed by the bound key.
+// attrs is used for other binding fields, can be nil.
+func (v *DPoPValidator) ValidateToken(tokenString, proof, method, rawURL string, attrs *ClientAttributes) (*Token, error) {
+	return v.ValidateTokenContext(context.Background(), tokenString, proof, method, rawURL, attrs)
+}
+
+// Same as ValidateToken, trace span is child of span in ctx.
+func (v *DPoPValidator) ValidateTokenContext(ctx context.Context, tokenString, proof, method, rawURL string, attrs *ClientAttributes) (*Token, error) {
+	return validateWith(ctx, tokenString, func(token *Token) error {
+		a := ClientAttributes{}
+		if attrs != nil {
+			a = *attrs
+		}
+		if len(proof) > 0 {
+			jkt, err := v.VerifyProof(proof, method, rawURL, tokenString)
+			if err != nil {
+				return err
+			}
+			a.JKT = jkt
+		}
+		if !token.Binding.Match(&a) {
+			return ERR_TokenBindingMismatch
+		}
+		return nil
+	})
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Compare htu without query and fragment, see RFC 9449 section 4.3.
+func sameHTU(htu, rawURL string) bool {
+	a, err1 := url.Parse(htu)
+	b, err2 := url.Parse(rawURL)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host) && a.EscapedPath() == b.EscapedPath()
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	D   string `json:"d,omitempty"`
+}
+
+// Parse public JWK, returns public key and its RFC 7638 thumbprint.
+func parseJWK(data []byte) (key crypto.PublicKey, thumbprint string, err error) {
+	k := &jwk{}
+	if err = json.Unmarshal(data, k); err != nil {
+		return nil, "", err
+	}
+	if len(k.D) > 0 {
+		return nil, "", errors.New("tokenauth: jwk contains private key")
+	}
+
+	var canonical string
+	switch k.Kty {
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, "", errors.New("tokenauth: unsupported jwk curve")
+		}
+		x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+		y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+		if err1 != nil || err2 != nil {
+			return nil, "", errors.New("tokenauth: invalid ec jwk")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, "", errors.New("tokenauth: invalid ec jwk")
+		}
+		key = pub
+		canonical = `{"crv":"` + k.Crv + `","kty":"EC","x":"` + k.X + `","y":"` + k.Y + `"}`
+	case "RSA":
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil || len(e) > 4 {
+			return nil, "", errors.New("tokenauth: invalid rsa jwk")
+		}
+		key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		canonical = `{"e":"` + k.E + `","kty":"RSA","n":"` + k.N + `"}`
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, "", errors.New("tokenauth: invalid okp jwk")
+		}
+		key = ed25519.PublicKey(x)
+		canonical = `{"crv":"Ed25519","kty":"OKP","x":"` + k.X + `"}`
+	default:
+		return nil, "", errors.New("tokenauth: unsupported jwk type")
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return key, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// Verify JWS signature, supports ES256, RS256 and EdDSA.
+func verifyJWS(alg string, key crypto.PublicKey, input, sig []byte) bool {
+	switch pub := key.(type) {
+	case *ecdsa.PublicKey:
+		if alg != "ES256" || len(sig) != 64 {
+			return false
+		}
+		hash := sha256.Sum256(input)
+		return ecdsa.Verify(pub, hash[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return false
+		}
+		hash := sha256.Sum256(input)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], sig) == nil
+	case ed25519.PublicKey:
+		return alg == "EdDSA" && ed25519.Verify(pub, input, sig)
+	}
+	return false
+}
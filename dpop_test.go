@@ -0,0 +1,114 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/ysqi/tokenauth"
+	. "gopkg.in/check.v1"
+	"time"
+)
+
+type dpopKey struct {
+	key *ecdsa.PrivateKey
+	jwk map[string]string
+	jkt string
+}
+
+func newDPoPKey() *dpopKey {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	b64 := base64.RawURLEncoding.EncodeToString
+	x, y := make([]byte, 32), make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	jwk := map[string]string{"kty": "EC", "crv": "P-256", "x": b64(x), "y": b64(y)}
+	sum := sha256.Sum256([]byte(fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`, jwk["x"], jwk["y"])))
+	return &dpopKey{key: key, jwk: jwk, jkt: b64(sum[:])}
+}
+
+var dpopJTI int
+
+func (k *dpopKey) proof(method, url, accessToken string, iat time.Time) string {
+	dpopJTI++
+	b64 := base64.RawURLEncoding.EncodeToString
+	header, _ := json.Marshal(map[string]interface{}{"typ": "dpop+jwt", "alg": "ES256", "jwk": k.jwk})
+	claims := map[string]interface{}{"jti": fmt.Sprint("jti-", dpopJTI), "htm": method, "htu": url, "iat": iat.Unix()}
+	if len(accessToken) > 0 {
+		sum := sha256.Sum256([]byte(accessToken))
+		claims["ath"] = b64(sum[:])
+	}
+	payload, _ := json.Marshal(claims)
+	input := b64(header) + "." + b64(payload)
+	hash := sha256.Sum256([]byte(input))
+	r, s, _ := ecdsa.Sign(rand.Reader, k.key, hash[:])
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return input + "." + b64(sig)
+}
+
+func (s *S) TestDPoP_VerifyProof(c *C) {
+
+	k := newDPoPKey()
+	v := tokenauth.NewDPoPValidator()
+	url := "https://api.example.com/resource"
+
+	proof := k.proof("GET", url+"?q=1", "", time.Now())
+	jkt, err := v.VerifyProof(proof, "GET", url, "")
+	c.Assert(err, IsNil)
+	c.Assert(jkt, Equals, k.jkt)
+
+	// Replay.
+	_, err = v.VerifyProof(proof, "GET", url, "")
+	c.Assert(err, Equals, tokenauth.ERR_DPoPProofInvalid)
+
+	cases := []struct {
+		proof, method, url, token string
+	}{
+		{k.proof("POST", url, "", time.Now()), "GET", url, ""},
+		{k.proof("GET", "https://other.example.com/resource", "", time.Now()), "GET", url, ""},
+		{k.proof("GET", url, "", time.Now().Add(-time.Hour)), "GET", url, ""},
+		{k.proof("GET", url, "", time.Now().Add(time.Hour)), "GET", url, ""},
+		{k.proof("GET", url, "token-a", time.Now()), "GET", url, "token-b"},
+		{k.proof("GET", url, "", time.Now())[:20], "GET", url, ""},
+		{"a.b.c", "GET", url, ""},
+	}
+	for i, t := range cases {
+		_, err = v.VerifyProof(t.proof, t.method, t.url, t.token)
+		c.Check(err, Equals, tokenauth.ERR_DPoPProofInvalid, Commentf("case %d", i))
+	}
+}
+
+func (s *S) TestDPoP_ValidateToken(c *C) {
+
+	k := newDPoPKey()
+	v := tokenauth.NewDPoPValidator()
+	url := "https://api.example.com/resource"
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret)
+	token, err := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString, tokenauth.WithDPoP(k.jkt))
+	c.Assert(err, IsNil)
+	c.Assert(token.Binding.JKT, Equals, k.jkt)
+
+	newToken, err := v.ValidateToken(token.Value, k.proof("GET", url, token.Value, time.Now()), "GET", url, nil)
+	c.Assert(err, IsNil)
+	c.Assert(newToken.Value, Equals, token.Value)
+
+	// Proof of other key.
+	_, err = v.ValidateToken(token.Value, newDPoPKey().proof("GET", url, token.Value, time.Now()), "GET", url, nil)
+	c.Assert(err, Equals, tokenauth.ERR_TokenBindingMismatch)
+
+	// Without proof.
+	_, err = v.ValidateToken(token.Value, "", "GET", url, nil)
+	c.Assert(err, Equals, tokenauth.ERR_TokenBindingMismatch)
+	_, err = tokenauth.ValidateBoundToken(token.Value, nil)
+	c.Assert(err, Equals, tokenauth.ERR_TokenBindingMismatch)
+}
@@ -5,15 +5,130 @@
 package tokenauth
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+)
+
+// Errors wrapped by stores, check with errors.Is.
+var (
+	ErrNotFound         = errors.New("tokenauth: not found")
+	ErrAudienceNotFound = fmt.Errorf("tokenauth: audience %w", ErrNotFound)
+	ErrInvalidArgument  = errors.New("tokenauth: invalid argument")
+	ErrStoreClosed      = errors.New("tokenauth: store is closed")
+	ErrTokenExists      = errors.New("tokenauth: token already exists")
+	ErrNotSupported     = errors.New("tokenauth: not supported by store")
 )
 
 //Customer error.
 type ValidationError struct {
-	Code string `json:"errcode"`
-	Msg  string `json:"errmsg"`
+	Code  string `json:"errcode"`
+	Msg   string `json:"errmsg"`
+	Cause error  `json:"-"` // Optional underlying error.
 }
 
 func (v ValidationError) Error() string {
+	if v.Cause != nil {
+		return fmt.Sprintf("%s:%s: %s", v.Code, v.Msg, v.Cause.Error())
+	}
 	return fmt.Sprintf("%s:%s", v.Code, v.Msg)
 }
+
+// Returns underlying error.
+func (v ValidationError) Unwrap() error {
+	return v.Cause
+}
+
+// Returns true if target is ValidationError with the same Code,
+// so errors.Is(err, ERR_InvalidateToken) ignores Cause.
+func (v ValidationError) Is(target error) bool {
+	t, ok := target.(ValidationError)
+	return ok && t.Code == v.Code
+}
+
+// Returns copy of error with underlying cause.
+func (v ValidationError) WithCause(cause error) ValidationError {
+	v.Cause = cause
+	return v
+}
+
+// Returns ValidationError in err chain.
+func AsValidationError(err error) (ValidationError, bool) {
+	var verr ValidationError
+	ok := errors.As(err, &verr)
+	return verr, ok
+}
+
+// Returns true if err is a resource limit error.
+func isLimitError(err error) bool {
+	return errors.Is(err, ERR_RateLimited) || errors.Is(err, ErrQuotaExceeded) ||
+		errors.Is(err, ErrIssueRateExceeded) || errors.Is(err, ErrTooManySessions)
+}
+
+// Returns HTTP status code of err.
+// ValidationError is 401 Unauthorized, limit errors are 429 Too Many Requests,
+// TokenAudienceNotAllowed and TokenScopeNotAllowed are 403 Forbidden,
+// ErrNotSupported is 501 Not Implemented.
+func HTTPStatus(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case isLimitError(err):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ERR_TokenAudienceNotAllowed), errors.Is(err, ERR_TokenScopeNotAllowed):
+		return http.StatusForbidden
+	case errors.As(err, new(ValidationError)):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrTokenExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrInvalidArgument):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrStoreClosed):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrNotSupported):
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
+
+// gRPC status codes, same values as google.golang.org/grpc/codes.
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcNotFound          = 5
+	grpcAlreadyExists     = 6
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+// Returns gRPC status code of err, convert with codes.Code(GRPCCode(err)).
+// Mapping is the same as HTTPStatus.
+func GRPCCode(err error) uint32 {
+	switch HTTPStatus(err) {
+	case http.StatusOK:
+		return grpcOK
+	case http.StatusTooManyRequests:
+		return grpcResourceExhausted
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcNotFound
+	case http.StatusConflict:
+		return grpcAlreadyExists
+	case http.StatusBadRequest:
+		return grpcInvalidArgument
+	case http.StatusServiceUnavailable:
+		return grpcUnavailable
+	case http.StatusNotImplemented:
+		return grpcUnimplemented
+	}
+	return grpcInternal
+}
@@ -0,0 +1,92 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ysqi/tokenauth"
+	. "gopkg.in/check.v1"
+	"net/http"
+	"time"
+)
+
+func (s *S) TestValidationError_Is(c *C) {
+
+	cause := errors.New("bad signature")
+	err := fmt.Errorf("request: %w", tokenauth.ERR_DPoPProofInvalid.WithCause(cause))
+
+	c.Assert(errors.Is(err, tokenauth.ERR_DPoPProofInvalid), Equals, true)
+	c.Assert(errors.Is(err, cause), Equals, true)
+	c.Assert(errors.Is(err, tokenauth.ERR_InvalidateToken), Equals, false)
+
+	verr, ok := tokenauth.AsValidationError(err)
+	c.Assert(ok, Equals, true)
+	c.Assert(verr.Code, Equals, tokenauth.ERR_DPoPProofInvalid.Code)
+	c.Assert(verr.Error(), Equals, "43002:Invalid DPoP proof: bad signature")
+}
+
+func (s *S) TestErrorStatus(c *C) {
+
+	cases := []struct {
+		err    error
+		status int
+		code   uint32
+	}{
+		{nil, http.StatusOK, 0},
+		{tokenauth.ERR_InvalidateToken, http.StatusUnauthorized, 16},
+		{tokenauth.ERR_RateLimited, http.StatusTooManyRequests, 8},
+		{tokenauth.ERR_TokenAudienceNotAllowed, http.StatusForbidden, 7},
+		{tokenauth.ErrQuotaExceeded, http.StatusTooManyRequests, 8},
+		{fmt.Errorf("x: %w", tokenauth.ErrAudienceNotFound), http.StatusNotFound, 5},
+		{tokenauth.ErrInvalidArgument, http.StatusBadRequest, 3},
+		{tokenauth.ErrStoreClosed, http.StatusServiceUnavailable, 14},
+		{tokenauth.ErrTokenExists, http.StatusConflict, 6},
+		{fmt.Errorf("x: %w", tokenauth.ErrNotSupported), http.StatusNotImplemented, 12},
+		{errors.New("other"), http.StatusInternalServerError, 13},
+	}
+	for i, t := range cases {
+		c.Check(tokenauth.HTTPStatus(t.err), Equals, t.status, Commentf("case %d", i))
+		c.Check(tokenauth.GRPCCode(t.err), Equals, t.code, Commentf("case %d", i))
+	}
+}
+
+func (s *S) TestStoreErrors(c *C) {
+
+	store := openBoltStore()
+
+	err := store.SaveToken(&tokenauth.Token{ClientID: "missing", Value: "token"})
+	c.Assert(errors.Is(err, tokenauth.ErrAudienceNotFound), Equals, true)
+	c.Assert(errors.Is(err, tokenauth.ErrNotFound), Equals, true)
+
+	err = store.SaveAudience(&tokenauth.Audience{})
+	c.Assert(errors.Is(err, tokenauth.ErrInvalidArgument), Equals, true)
+	_, err = store.GetToken("")
+	c.Assert(errors.Is(err, tokenauth.ErrInvalidArgument), Equals, true)
+
+	store.Close()
+	_, err = store.GetToken("token")
+	c.Assert(err, Equals, tokenauth.ErrStoreClosed)
+}
+
+func (s *S) TestErrNotSupported(c *C) {
+
+	// Wrapper hides optional interfaces of bolt store.
+	defer useStore(&countingStore{TokenStore: openBoltStore()})()
+
+	_, err := tokenauth.SingleSessions("client", "single")
+	c.Assert(errors.Is(err, tokenauth.ErrNotSupported), Equals, true)
+	_, err = tokenauth.AudienceSessions("client")
+	c.Assert(errors.Is(err, tokenauth.ErrNotSupported), Equals, true)
+	_, err = tokenauth.DisableAudience("client")
+	c.Assert(errors.Is(err, tokenauth.ErrNotSupported), Equals, true)
+
+	cache := tokenauth.NewCacheStore(tokenauth.Store, 10, time.Minute)
+	_, err = cache.CountTokens()
+	c.Assert(errors.Is(err, tokenauth.ErrNotSupported), Equals, true)
+
+	err = tokenauth.ChangeTokenStore(nil)
+	c.Assert(errors.Is(err, tokenauth.ErrInvalidArgument), Equals, true)
+}
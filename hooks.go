@@ -0,0 +1,147 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Type of lifecycle event.
+type EventType string
+
+const (
+	EventTokenIssued     EventType = "token.issued"     // New token saved, Token is set.
+	EventTokenValidated  EventType = "token.validated"  // Token validation pass, Token is set.
+	EventTokenRejected   EventType = "token.rejected"   // Token validation fail, Err and Code are set.
+	EventTokenExpired    EventType = "token.expired"    // Expired token found and deleted on validation.
+	EventTokenDeleted    EventType = "token.deleted"    // Token revoked by DeleteToken.
+	EventTokenEvicted    EventType = "token.evicted"    // Token deleted by quota or sessions when new token issued.
+	EventAudienceSaved   EventType = "audience.saved"   // Audience saved, Audience is set.
+	EventAudienceDeleted EventType = "audience.deleted" // Audience and all its tokens deleted.
+	EventAudienceStatus  EventType = "audience.status"  // Audience status changed, Audience is set.
+	EventJanitorExpired  EventType = "janitor.expired"  // Janitor deleted expired tokens, Count and Duration are set.
+	EventJanitorPurged   EventType = "janitor.purged"   // Janitor purged soft deleted audiences, Count and Duration are set.
+)
+
+// Lifecycle event.
+// Hooks must not modify event, it is shared by all hooks.
+type Event struct {
+	Type       EventType
+	Time       time.Time
+	Token      *Token
+	TokenValue string
+	Audience   *Audience
+	ClientID   string
+	Code       string // ValidationError code if validation fail.
+	Err        error
+	Count      int
+	Duration   time.Duration
+}
+
+// Hook func to observe events.
+type Hook func(event *Event)
+
+// Called with recovered value when hook panics.
+// Default writes log.
+var HookPanicHandler = func(event *Event, recovered interface{}) {
+	log.Printf("tokenauth: hook panic on %s event: %v", event.Type, recovered)
+}
+
+type hookEntry struct {
+	hook  Hook
+	async bool
+	types map[EventType]bool // nil is all types.
+}
+
+var (
+	hooksMu  sync.RWMutex
+	hooksSeq int
+	hooks    = make(map[int]*hookEntry)
+)
+
+// Add hook called synchronously on events of types, all events if types is empty.
+// Returns func to remove this hook.
+func AddHook(hook Hook, types ...EventType) (remove func()) {
+	return addHook(hook, false, types)
+}
+
+// Add hook called in new goroutine on events of types, all events if types is empty.
+// Returns func to remove this hook.
+func AddAsyncHook(hook Hook, types ...EventType) (remove func()) {
+	return addHook(hook, true, types)
+}
+
+func addHook(hook Hook, async bool, types []EventType) func() {
+	if hook == nil {
+		panic("tokenauth: hook is nil")
+	}
+	e := &hookEntry{hook: hook, async: async}
+	if len(types) > 0 {
+		e.types = make(map[EventType]bool, len(types))
+		for _, t := range types {
+			e.types[t] = true
+		}
+	}
+
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	hooksSeq++
+	id := hooksSeq
+	hooks[id] = e
+	return func() {
+		hooksMu.Lock()
+		delete(hooks, id)
+		hooksMu.Unlock()
+	}
+}
+
+// Remove all hooks.
+func RemoveHooks() {
+	hooksMu.Lock()
+	hooks = make(map[int]*hookEntry)
+	hooksMu.Unlock()
+}
+
+// Dispatch event to hooks.
+func emit(event *Event) {
+	hooksMu.RLock()
+	if len(hooks) == 0 {
+		hooksMu.RUnlock()
+		return
+	}
+	entries := make([]*hookEntry, 0, len(hooks))
+	for _, e := range hooks {
+		if e.types == nil || e.types[event.Type] {
+			entries = append(entries, e)
+		}
+	}
+	hooksMu.RUnlock()
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if v, ok := AsValidationError(event.Err); ok {
+		event.Code = v.Code
+	}
+	for _, e := range entries {
+		if e.async {
+			go callHook(e.hook, event)
+		} else {
+			callHook(e.hook, event)
+		}
+	}
+}
+
+// Call hook and recover panic.
+func callHook(hook Hook, event *Event) {
+	defer func() {
+		if r := recover(); r != nil && HookPanicHandler != nil {
+			HookPanicHandler(event, r)
+		}
+	}()
+	hook(event)
+}
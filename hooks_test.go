@@ -0,0 +1,87 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth_test
+
+import (
+	"github.com/ysqi/tokenauth"
+	. "gopkg.in/check.v1"
+	"time"
+)
+
+func (s *S) TestHooks_Sync(c *C) {
+
+	var events []*tokenauth.Event
+	remove := tokenauth.AddHook(func(e *tokenauth.Event) {
+		events = append(events, e)
+	})
+	defer remove()
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret)
+	token, _ := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+	tokenauth.ValidateToken(token.Value)
+	tokenauth.ValidateToken("empty")
+	tokenauth.DeleteToken(token.Value)
+	tokenauth.DeleteAudience(audience.ID)
+
+	types := make([]tokenauth.EventType, len(events))
+	for i, e := range events {
+		types[i] = e.Type
+	}
+	c.Assert(types, DeepEquals, []tokenauth.EventType{
+		tokenauth.EventAudienceSaved,
+		tokenauth.EventTokenIssued,
+		tokenauth.EventTokenValidated,
+		tokenauth.EventTokenRejected,
+		tokenauth.EventTokenDeleted,
+		tokenauth.EventAudienceDeleted,
+	})
+	c.Assert(events[1].Token, DeepEquals, token)
+	c.Assert(events[3].Code, Equals, tokenauth.ERR_InvalidateToken.Code)
+}
+
+func (s *S) TestHooks_Types(c *C) {
+
+	count := 0
+	remove := tokenauth.AddHook(func(e *tokenauth.Event) {
+		count++
+	}, tokenauth.EventTokenRejected)
+	defer remove()
+
+	tokenauth.ValidateToken("")
+	tokenauth.ValidateToken("empty")
+	tokenauth.NewAudience("forTest", NewSecret)
+	c.Assert(count, Equals, 2)
+}
+
+func (s *S) TestHooks_AsyncAndPanic(c *C) {
+
+	oldHandler := tokenauth.HookPanicHandler
+	defer func() { tokenauth.HookPanicHandler = oldHandler }()
+	panics := make(chan interface{}, 1)
+	tokenauth.HookPanicHandler = func(e *tokenauth.Event, r interface{}) {
+		panics <- r
+	}
+
+	done := make(chan *tokenauth.Event, 1)
+	remove1 := tokenauth.AddAsyncHook(func(e *tokenauth.Event) {
+		done <- e
+	}, tokenauth.EventTokenRejected)
+	defer remove1()
+	remove2 := tokenauth.AddHook(func(e *tokenauth.Event) {
+		panic("bad hook")
+	}, tokenauth.EventTokenRejected)
+	defer remove2()
+
+	_, err := tokenauth.ValidateToken("empty")
+	c.Assert(err, Equals, tokenauth.ERR_InvalidateToken)
+
+	select {
+	case e := <-done:
+		c.Assert(e.Type, Equals, tokenauth.EventTokenRejected)
+	case <-time.After(time.Second):
+		c.Fatal("async hook not called")
+	}
+	c.Assert(<-panics, Equals, "bad hook")
+}
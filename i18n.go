@@ -0,0 +1,191 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Message catalog of ValidationError, messages keyed by locale and Code.
+// Locales are matched case-insensitively, "zh" matches "zh-CN".
+type Catalog struct {
+	DefaultLocale string // Used if no locale matched.
+
+	mu       sync.RWMutex
+	locales  map[string]string // normalized locale -> locale.
+	messages map[string]map[string]string
+}
+
+// New empty catalog.
+func NewCatalog(defaultLocale string) *Catalog {
+	return &Catalog{
+		DefaultLocale: defaultLocale,
+		locales:       make(map[string]string),
+		messages:      make(map[string]map[string]string),
+	}
+}
+
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(locale), "_", "-", -1))
+}
+
+func baseLanguage(locale string) string {
+	if i := strings.IndexByte(locale, '-'); i > 0 {
+		return locale[:i]
+	}
+	return locale
+}
+
+// Add messages of locale, messages keyed by Code.
+// Existing messages of the same codes are replaced.
+func (c *Catalog) Add(locale string, messages map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := normalizeLocale(locale)
+	c.locales[key] = locale
+	m := c.messages[key]
+	if m == nil {
+		m = make(map[string]string)
+		c.messages[key] = m
+	}
+	for code, msg := range messages {
+		m[code] = msg
+	}
+}
+
+// Returns locales of catalog in sorted order.
+func (c *Catalog) Locales() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	locales := make([]string, 0, len(c.locales))
+	for _, l := range c.locales {
+		locales = append(locales, l)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Returns normalized catalog locale matching locale, empty if none.
+func (c *Catalog) match(locale string) string {
+	key := normalizeLocale(locale)
+	if len(key) == 0 {
+		return ""
+	}
+	if _, ok := c.messages[key]; ok {
+		return key
+	}
+	// Match by base language, e.g: "zh-TW" or "zh" matches "zh-cn".
+	base := baseLanguage(key)
+	candidates := make([]string, 0, 1)
+	for l := range c.messages {
+		if baseLanguage(l) == base {
+			candidates = append(candidates, l)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.Strings(candidates)
+	return candidates[0]
+}
+
+// Returns catalog locale of Accept-Language header value.
+// Languages are tried by quality order, returns DefaultLocale if none matched.
+func (c *Catalog) Resolve(acceptLanguage string) string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+	var langs []lang
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		l := lang{tag: strings.TrimSpace(fields[0]), q: 1}
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if q, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					l.q = q
+				}
+			}
+		}
+		if len(l.tag) > 0 && l.tag != "*" && l.q > 0 {
+			langs = append(langs, l)
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, l := range langs {
+		if key := c.match(l.tag); len(key) > 0 {
+			return c.locales[key]
+		}
+	}
+	return c.DefaultLocale
+}
+
+// Returns message of code in locale, falls back to DefaultLocale.
+func (c *Catalog) Message(locale, code string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, l := range []string{locale, c.DefaultLocale} {
+		if key := c.match(l); len(key) > 0 {
+			if msg, ok := c.messages[key][code]; ok {
+				return msg, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Returns err with message in locale if err is ValidationError,
+// else returns err. Cause is kept.
+func (c *Catalog) Localize(err error, locale string) error {
+	verr, ok := AsValidationError(err)
+	if !ok {
+		return err
+	}
+	if msg, ok := c.Message(locale, verr.Code); ok {
+		verr.Msg = msg
+	}
+	return verr
+}
+
+// Messages of all ValidationError codes, with built-in en and zh-CN locales.
+var Messages = NewCatalog("en")
+
+func init() {
+	Messages.Add("en", map[string]string{
+		ERR_InvalidateToken.Code:          ERR_InvalidateToken.Msg,
+		ERR_InvalidateAudienceSecret.Code: ERR_InvalidateAudienceSecret.Msg,
+		ERR_TokenEmpty.Code:               ERR_TokenEmpty.Msg,
+		ERR_TokenExpired.Code:             ERR_TokenExpired.Msg,
+		ERR_TokenBindingMismatch.Code:     ERR_TokenBindingMismatch.Msg,
+		ERR_DPoPProofInvalid.Code:         ERR_DPoPProofInvalid.Msg,
+		ERR_TokenAudienceNotAllowed.Code:  ERR_TokenAudienceNotAllowed.Msg,
+		ERR_TokenScopeNotAllowed.Code:     ERR_TokenScopeNotAllowed.Msg,
+		ERR_RateLimited.Code:              ERR_RateLimited.Msg,
+		ERR_AudienceDisabled.Code:         ERR_AudienceDisabled.Msg,
+		ERR_AudienceSuspended.Code:        ERR_AudienceSuspended.Msg,
+		ERR_AudienceDeleted.Code:          ERR_AudienceDeleted.Msg,
+	})
+	Messages.Add("zh-CN", map[string]string{
+		ERR_InvalidateToken.Code:          "无效的令牌",
+		ERR_InvalidateAudienceSecret.Code: "无效的客户端密钥",
+		ERR_TokenEmpty.Code:               "令牌为空",
+		ERR_TokenExpired.Code:             "令牌已过期",
+		ERR_TokenBindingMismatch.Code:     "令牌绑定不匹配",
+		ERR_DPoPProofInvalid.Code:         "无效的 DPoP 证明",
+		ERR_TokenAudienceNotAllowed.Code:  "令牌不属于允许的客户端",
+		ERR_TokenScopeNotAllowed.Code:     "令牌权限范围不被允许",
+		ERR_RateLimited.Code:              "请求过于频繁",
+		ERR_AudienceDisabled.Code:         "客户端已禁用",
+		ERR_AudienceSuspended.Code:        "客户端已暂停",
+		ERR_AudienceDeleted.Code:          "客户端已删除",
+	})
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/ysqi/tokenauth"
+	. "gopkg.in/check.v1"
+	"net/http"
+	"net/http/httptest"
+)
+
+func (s *S) TestCatalog_Resolve(c *C) {
+
+	cases := map[string]string{
+		"":                          "en",
+		"zh-CN":                     "zh-CN",
+		"zh":                        "zh-CN",
+		"zh-tw":                     "zh-CN",
+		"fr-FR, zh;q=0.8, en;q=0.9": "en",
+		"fr-FR, zh;q=0.9, en;q=0.8": "zh-CN",
+		"en-US,en;q=0.9":            "en",
+		"*":                         "en",
+		"zh-CN;q=0, en;q=0.1":       "en",
+	}
+	for header, locale := range cases {
+		c.Check(tokenauth.Messages.Resolve(header), Equals, locale, Commentf("%q", header))
+	}
+}
+
+func (s *S) TestCatalog_Localize(c *C) {
+
+	cause := errors.New("cause")
+	err := tokenauth.Messages.Localize(tokenauth.ERR_TokenExpired.WithCause(cause), "zh-CN")
+	verr, _ := tokenauth.AsValidationError(err)
+	c.Assert(verr.Msg, Equals, "令牌已过期")
+	c.Assert(errors.Is(err, cause), Equals, true)
+
+	c.Assert(tokenauth.Messages.Localize(cause, "zh-CN"), Equals, cause)
+
+	// Pluggable locale, missing codes fall back to default locale.
+	catalog := tokenauth.NewCatalog("en")
+	for _, l := range tokenauth.Messages.Locales() {
+		msg, _ := tokenauth.Messages.Message(l, tokenauth.ERR_TokenEmpty.Code)
+		catalog.Add(l, map[string]string{tokenauth.ERR_TokenEmpty.Code: msg})
+	}
+	catalog.Add("ja", map[string]string{tokenauth.ERR_TokenEmpty.Code: "トークンが空です"})
+	msg, _ := catalog.Message("ja-JP", tokenauth.ERR_TokenEmpty.Code)
+	c.Assert(msg, Equals, "トークンが空です")
+	_, ok := catalog.Message("ja", tokenauth.ERR_TokenExpired.Code)
+	c.Assert(ok, Equals, false)
+}
+
+func (s *S) TestCatalog_AllCodes(c *C) {
+	codes := []tokenauth.ValidationError{
+		tokenauth.ERR_InvalidateToken,
+		tokenauth.ERR_InvalidateAudienceSecret,
+		tokenauth.ERR_TokenEmpty,
+		tokenauth.ERR_TokenExpired,
+		tokenauth.ERR_TokenBindingMismatch,
+		tokenauth.ERR_DPoPProofInvalid,
+		tokenauth.ERR_TokenAudienceNotAllowed,
+		tokenauth.ERR_TokenScopeNotAllowed,
+		tokenauth.ERR_RateLimited,
+		tokenauth.ERR_AudienceDisabled,
+		tokenauth.ERR_AudienceSuspended,
+		tokenauth.ERR_AudienceDeleted,
+	}
+	for _, l := range []string{"en", "zh-CN"} {
+		for _, e := range codes {
+			_, ok := tokenauth.Messages.Message(l, e.Code)
+			c.Check(ok, Equals, true, Commentf("%s %s", l, e.Code))
+		}
+	}
+}
+
+func (s *S) TestMiddleware_Localize(c *C) {
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer guess")
+	r.Header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
+	w := httptest.NewRecorder()
+	tokenauth.NewMiddleware().Handler(http.NotFoundHandler()).ServeHTTP(w, r)
+
+	c.Assert(w.Code, Equals, http.StatusUnauthorized)
+	c.Assert(w.Header().Get("Content-Language"), Equals, "zh-CN")
+	var verr tokenauth.ValidationError
+	json.Unmarshal(w.Body.Bytes(), &verr)
+	c.Assert(verr, Equals, tokenauth.ValidationError{Code: "40001", Msg: "无效的令牌"})
+}
@@ -0,0 +1,156 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Generator of unique audience ID.
+type IDGenerator interface {
+	NewID() (string, error)
+}
+
+// Func adapter of IDGenerator.
+type IDGeneratorFunc func() (string, error)
+
+func (f IDGeneratorFunc) NewID() (string, error) {
+	return f()
+}
+
+// Built-in generators.
+var (
+	// Hex ObjectId, contains hostname hash, pid and creation time.
+	ObjectIdGenerator IDGenerator = IDGeneratorFunc(func() (string, error) { return NewObjectId().Hex(), nil })
+	// ULID, 26 chars Crockford base32, see https://github.com/ulid/spec.
+	ULIDGenerator IDGenerator = IDGeneratorFunc(NewULID)
+	// Random UUID version 4.
+	UUIDv4Generator IDGenerator = IDGeneratorFunc(NewUUIDv4)
+	// Time ordered UUID version 7, see RFC 9562.
+	UUIDv7Generator IDGenerator = IDGeneratorFunc(NewUUIDv7)
+)
+
+// Returns generator of hex string of n random bytes.
+// Generator returns error wraps ErrInvalidArgument if n <= 0.
+func RandomIDGenerator(n int) IDGenerator {
+	return IDGeneratorFunc(func() (string, error) {
+		if n <= 0 {
+			return "", fmt.Errorf("tokenauth: random id bytes %d: %w", n, ErrInvalidArgument)
+		}
+		b := make([]byte, n)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(b), nil
+	})
+}
+
+// Generator of new audience ID, default ObjectIdGenerator.
+var AudienceIDGenerator = ObjectIdGenerator
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// Returns new ULID, 48 bits unix milliseconds and 80 random bits.
+func NewULID() (string, error) {
+	var b [16]byte
+	putMillis(b[:], time.Now())
+	if _, err := rand.Read(b[6:]); err != nil {
+		return "", err
+	}
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockfordAlphabet[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:]), nil
+}
+
+// Returns timestamp of ULID.
+func ULIDTime(id string) (time.Time, error) {
+	if len(id) != 26 {
+		return time.Time{}, fmt.Errorf("tokenauth: invalid ULID %q: %w", id, ErrInvalidArgument)
+	}
+	var hi, lo uint64
+	for i, c := range strings.ToUpper(id) {
+		v := strings.IndexRune(crockfordAlphabet, c)
+		if v < 0 || (i == 0 && v > 7) {
+			return time.Time{}, fmt.Errorf("tokenauth: invalid ULID %q: %w", id, ErrInvalidArgument)
+		}
+		hi = hi<<5 | lo>>59
+		lo = lo<<5 | uint64(v)
+	}
+	return time.UnixMilli(int64(hi >> 16)), nil
+}
+
+// Returns new random UUID version 4.
+func NewUUIDv4() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return formatUUID(b, 4), nil
+}
+
+// Returns new UUID version 7, 48 bits unix milliseconds and random bits.
+func NewUUIDv7() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[6:]); err != nil {
+		return "", err
+	}
+	putMillis(b[:], time.Now())
+	return formatUUID(b, 7), nil
+}
+
+// Returns timestamp of UUID version 7.
+func UUIDv7Time(id string) (time.Time, error) {
+	b, err := hex.DecodeString(strings.Replace(id, "-", "", -1))
+	if err != nil || len(id) != 36 || len(b) != 16 || b[6]>>4 != 7 {
+		return time.Time{}, fmt.Errorf("tokenauth: invalid UUIDv7 %q: %w", id, ErrInvalidArgument)
+	}
+	ms := int64(binary.BigEndian.Uint16(b[:2]))<<32 | int64(binary.BigEndian.Uint32(b[2:6]))
+	return time.UnixMilli(ms), nil
+}
+
+// Returns creation time of id generated by ObjectIdGenerator, ULIDGenerator
+// or UUIDv7Generator. Returns false if id has no timestamp.
+func IDTime(id string) (time.Time, bool) {
+	switch len(id) {
+	case 24:
+		if oid, err := ObjectIdHex(id); err == nil {
+			t, _ := oid.Time()
+			return t, true
+		}
+	case 26:
+		if t, err := ULIDTime(id); err == nil {
+			return t, true
+		}
+	case 36:
+		if t, err := UUIDv7Time(id); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Put 48 bits unix milliseconds of t in b[:6].
+func putMillis(b []byte, t time.Time) {
+	ms := uint64(t.UnixMilli())
+	binary.BigEndian.PutUint16(b[:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:6], uint32(ms))
+}
+
+func formatUUID(b [16]byte, version byte) string {
+	b[6] = b[6]&0x0f | version<<4
+	b[8] = b[8]&0x3f | 0x80
+	s := hex.EncodeToString(b[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
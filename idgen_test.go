@@ -0,0 +1,78 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth_test
+
+import (
+	"errors"
+	"github.com/ysqi/tokenauth"
+	. "gopkg.in/check.v1"
+	"regexp"
+	"time"
+)
+
+func (s *S) TestIDGenerator_Formats(c *C) {
+
+	cases := []struct {
+		gen     tokenauth.IDGenerator
+		pattern string
+		timed   bool
+	}{
+		{tokenauth.ObjectIdGenerator, `^[0-9a-f]{24}$`, true},
+		{tokenauth.ULIDGenerator, `^[0-7][0-9A-HJKMNP-TV-Z]{25}$`, true},
+		{tokenauth.UUIDv4Generator, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, false},
+		{tokenauth.UUIDv7Generator, `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, true},
+		{tokenauth.RandomIDGenerator(16), `^[0-9a-f]{32}$`, false},
+	}
+	for i, t := range cases {
+		start := time.Now().Truncate(time.Second)
+		id, err := t.gen.NewID()
+		c.Assert(err, IsNil)
+		c.Check(regexp.MustCompile(t.pattern).MatchString(id), Equals, true, Commentf("case %d %s", i, id))
+
+		other, _ := t.gen.NewID()
+		c.Check(other, Not(Equals), id)
+
+		at, ok := tokenauth.IDTime(id)
+		c.Check(ok, Equals, t.timed, Commentf("case %d", i))
+		if t.timed {
+			c.Check(at.Before(start) || at.After(time.Now()), Equals, false, Commentf("case %d %s", i, at))
+		}
+	}
+}
+
+func (s *S) TestIDGenerator_RandomEmpty(c *C) {
+
+	for _, n := range []int{0, -1} {
+		id, err := tokenauth.RandomIDGenerator(n).NewID()
+		c.Assert(errors.Is(err, tokenauth.ErrInvalidArgument), Equals, true)
+		c.Assert(id, Equals, "")
+	}
+}
+
+func (s *S) TestIDGenerator_ParseTime(c *C) {
+
+	at, err := tokenauth.ULIDTime("01ARZ3NDEKTSV4RRFFQ69G5FAV")
+	c.Assert(err, IsNil)
+	c.Assert(at.UnixMilli(), Equals, int64(1469922850259))
+
+	at, err = tokenauth.UUIDv7Time("017f22e2-79b0-7cc3-98c4-dc0c0c07398f")
+	c.Assert(err, IsNil)
+	c.Assert(at.UnixMilli(), Equals, int64(0x017f22e279b0))
+
+	_, err = tokenauth.ULIDTime("81ARZ3NDEKTSV4RRFFQ69G5FAV")
+	c.Assert(err, NotNil)
+	_, err = tokenauth.UUIDv7Time("017f22e2-79b0-4cc3-98c4-dc0c0c07398f")
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestIDGenerator_Audience(c *C) {
+
+	tokenauth.AudienceIDGenerator = tokenauth.ULIDGenerator
+	defer func() { tokenauth.AudienceIDGenerator = tokenauth.ObjectIdGenerator }()
+
+	audience := tokenauth.NewAudienceNotStore("forTest", NewSecret)
+	_, err := tokenauth.ULIDTime(audience.ID)
+	c.Assert(err, IsNil)
+}
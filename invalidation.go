@@ -0,0 +1,105 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"sync"
+)
+
+// Kind of invalidation event.
+type InvalidationKind string
+
+const (
+	// Token revoked, Fingerprint is set.
+	InvalidateRevoke InvalidationKind = "revoke"
+	// Audience and all tokens of audience deleted, ClientID is set.
+	InvalidateDelete InvalidationKind = "delete"
+	// Token or audience replaced by new one.
+	// Fingerprint is set for token, ClientID for audience,
+	// SingleID and SingleClientID for single token whose old token is replaced.
+	InvalidateRotate InvalidationKind = "rotate"
+	// Events may be lost, e.g: bus reconnected, subscriber clears all cached data.
+	// Only delivered to local subscribers, never published.
+	InvalidatePurge InvalidationKind = "purge"
+)
+
+// Invalidation event, published when cached data is out of date.
+// Token is identified by TokenFingerprint, token string is never published.
+type InvalidationEvent struct {
+	Kind           InvalidationKind `json:"kind"`
+	Fingerprint    string           `json:"fingerprint,omitempty"` // TokenFingerprint of token.
+	ClientID       string           `json:"client,omitempty"`
+	SingleID       string           `json:"single,omitempty"`
+	SingleClientID string           `json:"single_client,omitempty"` // Audience of SingleID.
+	Origin         string           `json:"origin,omitempty"`        // Publisher id, subscriber can skip own events.
+}
+
+// Invalidation bus interface.
+// Stores and caches publish events to bus and subscribe from it,
+// so that revocations on one node are seen on other nodes.
+type InvalidationBus interface {
+
+	// Publish event to all subscribers.
+	Publish(event *InvalidationEvent) error
+
+	// Subscribe events.
+	// Returns func to cancel this subscription.
+	Subscribe(handler func(event *InvalidationEvent)) (cancel func(), err error)
+
+	// Close bus and stop all subscriptions.
+	Close() error
+}
+
+// In-process invalidation bus.
+// Handlers are called synchronously in Publish.
+type MemoryBus struct {
+	mu       sync.RWMutex
+	seq      int
+	handlers map[int]func(event *InvalidationEvent)
+}
+
+// New in-process invalidation bus.
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{handlers: make(map[int]func(event *InvalidationEvent))}
+}
+
+// Publish event to all subscribers.
+func (b *MemoryBus) Publish(event *InvalidationEvent) error {
+	b.mu.RLock()
+	handlers := make([]func(event *InvalidationEvent), 0, len(b.handlers))
+	for _, h := range b.handlers {
+		handlers = append(handlers, h)
+	}
+	b.mu.RUnlock()
+
+	for _, h := range handlers {
+		e := *event
+		h(&e)
+	}
+	return nil
+}
+
+// Subscribe events.
+func (b *MemoryBus) Subscribe(handler func(event *InvalidationEvent)) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	id := b.seq
+	b.handlers[id] = handler
+	return func() {
+		b.mu.Lock()
+		delete(b.handlers, id)
+		b.mu.Unlock()
+	}, nil
+}
+
+// Close bus and remove all subscribers.
+func (b *MemoryBus) Close() error {
+	b.mu.Lock()
+	b.handlers = make(map[int]func(event *InvalidationEvent))
+	b.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,355 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Default redis channel of invalidation events.
+const DefaultRedisChannel = "tokenauth:invalidation"
+
+// Invalidation bus over the redis protocol, use redis PUBLISH and SUBSCRIBE.
+// Subscription reconnects automatically until bus closed,
+// subscribers get InvalidatePurge event after reconnected as events may be lost.
+type RedisBus struct {
+	Addr        string
+	Password    string
+	Channel     string
+	DialTimeout time.Duration
+	IOTimeout   time.Duration // Timeout of each command, 0 is no timeout. Subscription waits messages without timeout.
+
+	pubMu   sync.Mutex
+	pubConn *redisConn
+
+	subMu    sync.Mutex
+	seq      int
+	handlers map[int]func(event *InvalidationEvent)
+	subConn  *redisConn
+	running  bool
+
+	closed chan struct{}
+	once   sync.Once
+}
+
+// New redis invalidation bus.
+// Use DefaultRedisChannel if channel is empty.
+func NewRedisBus(addr, password, channel string) *RedisBus {
+	if len(channel) == 0 {
+		channel = DefaultRedisChannel
+	}
+	return &RedisBus{
+		Addr:        addr,
+		Password:    password,
+		Channel:     channel,
+		DialTimeout: 5 * time.Second,
+		IOTimeout:   5 * time.Second,
+		handlers:    make(map[int]func(event *InvalidationEvent)),
+		closed:      make(chan struct{}),
+	}
+}
+
+// Publish event to redis channel.
+// Retry once on a new connection if publish fail.
+func (b *RedisBus) Publish(event *InvalidationEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	b.pubMu.Lock()
+	defer b.pubMu.Unlock()
+
+	for i := 0; i < 2; i++ {
+		if b.pubConn == nil {
+			if b.pubConn, err = b.dial(); err != nil {
+				return err
+			}
+		}
+		if _, err = b.pubConn.do("PUBLISH", b.Channel, string(payload)); err == nil {
+			return nil
+		}
+		if _, ok := err.(redisError); ok {
+			return err
+		}
+		b.pubConn.Close()
+		b.pubConn = nil
+	}
+	return err
+}
+
+// Subscribe events.
+// The first subscription connects to redis and returns error if fail.
+func (b *RedisBus) Subscribe(handler func(event *InvalidationEvent)) (func(), error) {
+	b.subMu.Lock()
+	defer b.subMu.Unlock()
+
+	select {
+	case <-b.closed:
+		return nil, errors.New("tokenauth: redis bus is closed")
+	default:
+	}
+
+	if !b.running {
+		conn, err := b.subscribe()
+		if err != nil {
+			return nil, err
+		}
+		b.subConn = conn
+		b.running = true
+		go b.receive(conn)
+	}
+
+	b.seq++
+	id := b.seq
+	b.handlers[id] = handler
+	return func() {
+		b.subMu.Lock()
+		delete(b.handlers, id)
+		b.subMu.Unlock()
+	}, nil
+}
+
+// Close all connections and stop subscription.
+func (b *RedisBus) Close() error {
+	b.once.Do(func() {
+		close(b.closed)
+	})
+
+	b.pubMu.Lock()
+	if b.pubConn != nil {
+		b.pubConn.Close()
+		b.pubConn = nil
+	}
+	b.pubMu.Unlock()
+
+	b.subMu.Lock()
+	if b.subConn != nil {
+		b.subConn.Close()
+	}
+	b.handlers = make(map[int]func(event *InvalidationEvent))
+	b.subMu.Unlock()
+	return nil
+}
+
+// Dial redis and auth if need.
+func (b *RedisBus) dial() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", b.Addr, b.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn), timeout: b.IOTimeout}
+	if len(b.Password) > 0 {
+		if _, err = c.do("AUTH", b.Password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Dial redis and subscribe channel.
+func (b *RedisBus) subscribe() (*redisConn, error) {
+	c, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = c.do("SUBSCRIBE", b.Channel); err != nil {
+		c.Close()
+		return nil, err
+	}
+	// Wait messages without deadline.
+	if err = c.conn.SetDeadline(time.Time{}); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Read messages until bus closed, reconnect if connection broken.
+func (b *RedisBus) receive(conn *redisConn) {
+	backoff := 100 * time.Millisecond
+	for {
+		for {
+			reply, err := conn.readReply()
+			if err != nil {
+				break
+			}
+			backoff = 100 * time.Millisecond
+			b.dispatch(reply)
+		}
+		conn.Close()
+
+		for {
+			select {
+			case <-b.closed:
+				return
+			case <-time.After(backoff):
+			}
+			if backoff < 5*time.Second {
+				backoff *= 2
+			}
+			var err error
+			if conn, err = b.subscribe(); err == nil {
+				break
+			}
+		}
+
+		b.subMu.Lock()
+		select {
+		case <-b.closed:
+			b.subMu.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		b.subConn = conn
+		b.subMu.Unlock()
+
+		// Events published while disconnected are lost.
+		b.notify(&InvalidationEvent{Kind: InvalidatePurge})
+	}
+}
+
+// Dispatch message reply to handlers.
+func (b *RedisBus) dispatch(reply interface{}) {
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != 3 {
+		return
+	}
+	if kind, _ := items[0].([]byte); string(kind) != "message" {
+		return
+	}
+	payload, _ := items[2].([]byte)
+	event := &InvalidationEvent{}
+	if err := json.Unmarshal(payload, event); err != nil || event.Kind == InvalidatePurge {
+		return
+	}
+	b.notify(event)
+}
+
+// Call handlers with event.
+func (b *RedisBus) notify(event *InvalidationEvent) {
+	b.subMu.Lock()
+	handlers := make([]func(event *InvalidationEvent), 0, len(b.handlers))
+	for _, h := range b.handlers {
+		handlers = append(handlers, h)
+	}
+	b.subMu.Unlock()
+
+	for _, h := range handlers {
+		e := *event
+		h(&e)
+	}
+}
+
+// Error reply from redis.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// Redis protocol (RESP) connection.
+type redisConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	timeout time.Duration // deadline of each command, 0 is no deadline.
+}
+
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
+
+// Send command and read reply in timeout.
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	if c.timeout > 0 {
+		if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.writeCommand(args...); err != nil {
+		return nil, err
+	}
+	reply, err := c.readReply()
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(redisError); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// Write command as array of bulk strings.
+func (c *redisConn) writeCommand(args ...string) error {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// Read one reply.
+// Returns string for simple string, redisError for error, int64 for integer,
+// []byte for bulk string and []interface{} for array.
+func (c *redisConn) readReply() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: invalid reply line")
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err = io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+}
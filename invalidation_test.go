@@ -0,0 +1,289 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ysqi/tokenauth"
+	. "gopkg.in/check.v1"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func (s *S) TestInvalidation_MemoryBus(c *C) {
+
+	bus := tokenauth.NewMemoryBus()
+	defer bus.Close()
+
+	var got []*tokenauth.InvalidationEvent
+	cancel, err := bus.Subscribe(func(e *tokenauth.InvalidationEvent) {
+		got = append(got, e)
+	})
+	c.Assert(err, IsNil)
+
+	bus.Publish(&tokenauth.InvalidationEvent{Kind: tokenauth.InvalidateRevoke, Fingerprint: "t1"})
+	cancel()
+	bus.Publish(&tokenauth.InvalidationEvent{Kind: tokenauth.InvalidateRevoke, Fingerprint: "t2"})
+
+	c.Assert(len(got), Equals, 1)
+	c.Assert(got[0].Fingerprint, Equals, "t1")
+}
+
+func (s *S) TestInvalidation_CacheStore(c *C) {
+
+	bolt := openBoltStore()
+	defer bolt.Close()
+	bus := tokenauth.NewMemoryBus()
+	defer bus.Close()
+
+	nodeA := tokenauth.NewCacheStore(bolt, 100, time.Minute)
+	nodeB := tokenauth.NewCacheStore(bolt, 100, time.Minute)
+	c.Assert(nodeA.UseBus(bus), IsNil)
+	c.Assert(nodeB.UseBus(bus), IsNil)
+
+	item := newAudience()
+	nodeA.SaveAudience(item)
+	token, _ := tokenauth.NewToken(item, keyPorvider.GenerateTokenString)
+	nodeA.SaveToken(token)
+
+	newToken, _ := nodeB.GetToken(token.Value)
+	c.Assert(newToken, DeepEquals, token)
+
+	var events []*tokenauth.InvalidationEvent
+	cancel, _ := bus.Subscribe(func(e *tokenauth.InvalidationEvent) {
+		events = append(events, e)
+	})
+
+	// Revoke on node A is seen on node B.
+	err := nodeA.DeleteToken(token.Value)
+	c.Assert(err, IsNil)
+	cancel()
+	c.Assert(len(events), Equals, 1)
+	c.Assert(events[0].Fingerprint, Equals, tokenauth.TokenFingerprint(token.Value))
+	data, _ := json.Marshal(events[0])
+	c.Assert(strings.Contains(string(data), token.Value), Equals, false)
+	newToken, err = nodeB.GetToken(token.Value)
+	c.Assert(err, IsNil)
+	c.Assert(newToken, IsNil)
+
+	// Audience delete on node B is seen on node A.
+	nodeA.GetAudience(item.ID)
+	err = nodeB.DeleteAudience(item.ID)
+	c.Assert(err, IsNil)
+	newItem, err := nodeA.GetAudience(item.ID)
+	c.Assert(err, IsNil)
+	c.Assert(newItem, IsNil)
+}
+
+// failBus fails all publishes.
+type failBus struct{}
+
+func (failBus) Publish(event *tokenauth.InvalidationEvent) error {
+	return errors.New("bus is down")
+}
+
+func (failBus) Subscribe(handler func(event *tokenauth.InvalidationEvent)) (func(), error) {
+	return func() {}, nil
+}
+
+func (failBus) Close() error { return nil }
+
+func (s *S) TestInvalidation_PublishError(c *C) {
+
+	st := tokenauth.NewCacheStore(openBoltStore(), 100, time.Minute)
+	c.Assert(st.UseBus(failBus{}), IsNil)
+	var failed []*tokenauth.InvalidationEvent
+	st.OnPublishError = func(e *tokenauth.InvalidationEvent, err error) {
+		failed = append(failed, e)
+	}
+	defer useStore(st)()
+
+	// Store changes succeed, publish errors go to handler.
+	audience, err := tokenauth.NewAudience("forTest", NewSecret)
+	c.Assert(err, IsNil)
+	token, err := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+	c.Assert(err, IsNil)
+	c.Assert(tokenauth.DeleteToken(token.Value), IsNil)
+	c.Assert(len(failed), Equals, 3)
+	c.Assert(failed[2].Kind, Equals, tokenauth.InvalidateRevoke)
+}
+
+func (s *S) TestInvalidation_RedisBus(c *C) {
+
+	server := newFakeRedis(c)
+	defer server.Close()
+
+	pub := tokenauth.NewRedisBus(server.Addr(), "", "")
+	defer pub.Close()
+	sub := tokenauth.NewRedisBus(server.Addr(), "", "")
+	defer sub.Close()
+
+	got := make(chan *tokenauth.InvalidationEvent, 1)
+	_, err := sub.Subscribe(func(e *tokenauth.InvalidationEvent) {
+		got <- e
+	})
+	c.Assert(err, IsNil)
+
+	err = pub.Publish(&tokenauth.InvalidationEvent{Kind: tokenauth.InvalidateDelete, ClientID: "client"})
+	c.Assert(err, IsNil)
+
+	select {
+	case e := <-got:
+		c.Assert(e.Kind, Equals, tokenauth.InvalidateDelete)
+		c.Assert(e.ClientID, Equals, "client")
+	case <-time.After(3 * time.Second):
+		c.Fatal("event not received")
+	}
+}
+
+func (s *S) TestInvalidation_RedisBus_Reconnect(c *C) {
+
+	server := newFakeRedis(c)
+	defer server.Close()
+
+	sub := tokenauth.NewRedisBus(server.Addr(), "", "")
+	defer sub.Close()
+
+	bolt := openBoltStore()
+	defer bolt.Close()
+	st := tokenauth.NewCacheStore(bolt, 100, time.Minute)
+	c.Assert(st.UseBus(sub), IsNil)
+
+	st.GetToken("unknown")
+	c.Assert(st.Len(), Equals, 1)
+
+	// Cache is purged after reconnected.
+	server.Kick()
+	for i := 0; i < 30 && st.Len() > 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Assert(st.Len(), Equals, 0)
+}
+
+func (s *S) TestInvalidation_RedisBus_Timeout(c *C) {
+
+	// Server accepts connections but never replies.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	bus := tokenauth.NewRedisBus(ln.Addr().String(), "", "")
+	bus.IOTimeout = 100 * time.Millisecond
+	defer bus.Close()
+
+	start := time.Now()
+	err = bus.Publish(&tokenauth.InvalidationEvent{Kind: tokenauth.InvalidateDelete, ClientID: "client"})
+	c.Assert(err, NotNil)
+	c.Assert(time.Since(start) < 2*time.Second, Equals, true)
+}
+
+// fakeRedis is a tiny redis server supporting SUBSCRIBE and PUBLISH only.
+type fakeRedis struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	subs map[string][]net.Conn
+}
+
+func newFakeRedis(c *C) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	f := &fakeRedis{ln: ln, subs: make(map[string][]net.Conn)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) Addr() string {
+	return f.ln.Addr().String()
+}
+
+func (f *fakeRedis) Close() {
+	f.ln.Close()
+}
+
+// Close connections of all subscribers.
+func (f *fakeRedis) Kick() {
+	f.mu.Lock()
+	for ch, subs := range f.subs {
+		for _, sub := range subs {
+			sub.Close()
+		}
+		delete(f.subs, ch)
+	}
+	f.mu.Unlock()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(args[0]) {
+		case "SUBSCRIBE":
+			f.mu.Lock()
+			f.subs[args[1]] = append(f.subs[args[1]], conn)
+			f.mu.Unlock()
+			fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(args[1]), args[1])
+		case "PUBLISH":
+			f.mu.Lock()
+			subs := f.subs[args[1]]
+			for _, sub := range subs {
+				fmt.Fprintf(sub, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n",
+					len(args[1]), args[1], len(args[2]), args[2])
+			}
+			f.mu.Unlock()
+			fmt.Fprintf(conn, ":%d\r\n", len(subs))
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command\r\n")
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
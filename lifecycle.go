@@ -0,0 +1,183 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Lifecycle status of audience.
+type AudienceStatus int
+
+const (
+	AudienceActive    AudienceStatus = iota // Tokens are issued and validated.
+	AudienceDisabled                        // Tokens are rejected until enabled.
+	AudienceSuspended                       // Tokens are rejected until Audience.SuspendedUntil.
+	AudienceDeleted                         // Soft deleted, purged by janitor after AudienceRetention.
+)
+
+var audienceStatusNames = map[AudienceStatus]string{
+	AudienceActive:    "active",
+	AudienceDisabled:  "disabled",
+	AudienceSuspended: "suspended",
+	AudienceDeleted:   "deleted",
+}
+
+func (s AudienceStatus) String() string {
+	if name, ok := audienceStatusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("AudienceStatus(%d)", int(s))
+}
+
+// Returns status of name: "active", "disabled", "suspended" or "deleted".
+func ParseAudienceStatus(name string) (AudienceStatus, error) {
+	for s, n := range audienceStatusNames {
+		if n == name {
+			return s, nil
+		}
+	}
+	return AudienceActive, fmt.Errorf("tokenauth: unknown audience status %q: %w", name, ErrInvalidArgument)
+}
+
+// Time soft deleted audience is kept before purged by janitor.
+// Default is 30 days.
+var AudienceRetention = 30 * 24 * time.Hour
+
+var (
+	ERR_AudienceDisabled  = ValidationError{Code: "45001", Msg: "Audience is disabled"}
+	ERR_AudienceSuspended = ValidationError{Code: "45002", Msg: "Audience is suspended"}
+	ERR_AudienceDeleted   = ValidationError{Code: "45003", Msg: "Audience is deleted"}
+)
+
+// Returns nil if audience is active or its suspension ended,
+// else returns ValidationError of status.
+func (a *Audience) CheckStatus() error {
+	switch a.Status {
+	case AudienceDisabled:
+		return ERR_AudienceDisabled
+	case AudienceSuspended:
+		if a.SuspendedUntil == 0 || time.Now().Unix() < a.SuspendedUntil {
+			return ERR_AudienceSuspended
+		}
+	case AudienceDeleted:
+		return ERR_AudienceDeleted
+	}
+	return nil
+}
+
+// Optional interface implemented by stores which can change saved audience.
+type AudienceUpdater interface {
+
+	// Save audience info, tokens of audience are kept.
+	// Returns error wraps ErrAudienceNotFound if audience is not saved.
+	UpdateAudience(audience *Audience) error
+}
+
+// Optional interface implemented by stores which can purge soft deleted audiences.
+// Janitor calls it with now - AudienceRetention.
+type AudiencePurger interface {
+
+	// Delete audiences and their tokens which are soft deleted before deletedBefore, time unix.
+	// Returns count of deleted audiences.
+	PurgeAudiences(deletedBefore int64) (int, error)
+}
+
+// Disable audience, tokens are rejected and not issued until EnableAudience.
+// Store must implement AudienceUpdater.
+func DisableAudience(clientID string) (*Audience, error) {
+	return updateAudience(clientID, EventAudienceStatus, func(a *Audience) {
+		a.Status, a.SuspendedUntil, a.DeletedAt = AudienceDisabled, 0, 0
+	})
+}
+
+// Suspend audience until time, tokens are rejected and not issued before it.
+// Store must implement AudienceUpdater.
+func SuspendAudience(clientID string, until time.Time) (*Audience, error) {
+	return updateAudience(clientID, EventAudienceStatus, func(a *Audience) {
+		a.Status, a.SuspendedUntil, a.DeletedAt = AudienceSuspended, until.Unix(), 0
+	})
+}
+
+// Set audience active, also restores soft deleted audience not purged.
+// Store must implement AudienceUpdater.
+func EnableAudience(clientID string) (*Audience, error) {
+	return updateAudience(clientID, EventAudienceStatus, func(a *Audience) {
+		a.Status, a.SuspendedUntil, a.DeletedAt = AudienceActive, 0, 0
+	})
+}
+
+// Soft delete audience, tokens are rejected and not issued.
+// Audience and its tokens are purged by janitor after AudienceRetention,
+// use DeleteAudience to delete at once.
+// Store must implement AudienceUpdater.
+func SoftDeleteAudience(clientID string) (*Audience, error) {
+	return updateAudience(clientID, EventAudienceStatus, func(a *Audience) {
+		a.Status, a.SuspendedUntil, a.DeletedAt = AudienceDeleted, 0, time.Now().Unix()
+	})
+}
+
+// Change saved audience by set and emit event of eventType.
+func updateAudience(clientID string, eventType EventType, set func(a *Audience)) (*Audience, error) {
+	updater, ok := Store.(AudienceUpdater)
+	if !ok {
+		return nil, fmt.Errorf("tokenauth: store can not update audience: %w", ErrNotSupported)
+	}
+	ctx := context.Background()
+	var audience *Audience
+	err := traceStore(ctx, Store, "GetAudience", func() (err error) {
+		audience, err = Store.GetAudience(clientID)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	if audience == nil {
+		return nil, fmt.Errorf("tokenauth: audience %s: %w", clientID, ErrAudienceNotFound)
+	}
+	set(audience)
+	if err = traceStore(ctx, Store, "UpdateAudience", func() error { return updater.UpdateAudience(audience) }); err != nil {
+		return nil, err
+	}
+	emit(&Event{Type: eventType, Audience: audience, ClientID: audience.ID})
+	return audience, nil
+}
+
+// Returns saved audience clientID, or status error if audience is not active.
+// Returns nil audience if clientID is empty or audience is not saved.
+func activeAudience(ctx context.Context, clientID string) (*Audience, error) {
+	if len(clientID) == 0 {
+		return nil, nil
+	}
+	var audience *Audience
+	err := traceStore(ctx, Store, "GetAudience", func() (err error) {
+		audience, err = Store.GetAudience(clientID)
+		return
+	})
+	if err != nil || audience == nil {
+		return nil, err
+	}
+	if err = audience.CheckStatus(); err != nil {
+		return nil, err
+	}
+	return audience, nil
+}
+
+// Purge soft deleted audiences if store is AudiencePurger.
+func purgeAudiences(store TokenStore) {
+	purger, ok := store.(AudiencePurger)
+	if !ok {
+		return
+	}
+	start := time.Now()
+	var count int
+	err := traceStore(context.Background(), store, "PurgeAudiences", func() (err error) {
+		count, err = purger.PurgeAudiences(start.Add(-AudienceRetention).Unix())
+		return
+	})
+	emit(&Event{Type: EventJanitorPurged, Time: start, Count: count, Err: err, Duration: time.Since(start)})
+}
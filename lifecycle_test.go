@@ -0,0 +1,105 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ysqi/tokenauth"
+	. "gopkg.in/check.v1"
+	"time"
+)
+
+func (s *S) TestAudienceStatus_Parse(c *C) {
+
+	for _, st := range []tokenauth.AudienceStatus{tokenauth.AudienceActive, tokenauth.AudienceDisabled,
+		tokenauth.AudienceSuspended, tokenauth.AudienceDeleted} {
+		parsed, err := tokenauth.ParseAudienceStatus(st.String())
+		c.Assert(err, IsNil)
+		c.Assert(parsed, Equals, st)
+	}
+	_, err := tokenauth.ParseAudienceStatus("gone")
+	c.Assert(errors.Is(err, tokenauth.ErrInvalidArgument), Equals, true)
+}
+
+func (s *S) TestAudienceStatus_Disable(c *C) {
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret)
+	token, _ := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+
+	_, err := tokenauth.DisableAudience(audience.ID)
+	c.Assert(err, IsNil)
+	_, err = tokenauth.ValidateToken(token.Value)
+	c.Assert(err, Equals, tokenauth.ERR_AudienceDisabled)
+	_, err = tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+	c.Assert(err, Equals, tokenauth.ERR_AudienceDisabled)
+	_, err = tokenauth.VerifyAudienceSecret(context.Background(), audience.ID, audience.Secret)
+	c.Assert(err, Equals, tokenauth.ERR_AudienceDisabled)
+
+	// Tokens are kept.
+	enabled, err := tokenauth.EnableAudience(audience.ID)
+	c.Assert(err, IsNil)
+	c.Assert(enabled.Status, Equals, tokenauth.AudienceActive)
+	_, err = tokenauth.ValidateToken(token.Value)
+	c.Assert(err, IsNil)
+
+	_, err = tokenauth.DisableAudience("unknown")
+	c.Assert(errors.Is(err, tokenauth.ErrAudienceNotFound), Equals, true)
+}
+
+func (s *S) TestAudienceStatus_Suspend(c *C) {
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret)
+	token, _ := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+
+	_, err := tokenauth.SuspendAudience(audience.ID, time.Now().Add(time.Hour))
+	c.Assert(err, IsNil)
+	_, err = tokenauth.ValidateToken(token.Value)
+	c.Assert(err, Equals, tokenauth.ERR_AudienceSuspended)
+	_, err = tokenauth.NewSingleToken("user", audience, keyPorvider.GenerateTokenString)
+	c.Assert(err, Equals, tokenauth.ERR_AudienceSuspended)
+
+	// Suspension ended.
+	_, err = tokenauth.SuspendAudience(audience.ID, time.Now().Add(-time.Second))
+	c.Assert(err, IsNil)
+	_, err = tokenauth.ValidateToken(token.Value)
+	c.Assert(err, IsNil)
+}
+
+func (s *S) TestAudienceStatus_SoftDelete(c *C) {
+
+	st := openBoltStore()
+	defer useStore(st)()
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret)
+	token, _ := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+	other, _ := tokenauth.NewAudience("other", NewSecret)
+
+	deleted, err := tokenauth.SoftDeleteAudience(audience.ID)
+	c.Assert(err, IsNil)
+	c.Assert(deleted.DeletedAt > 0, Equals, true)
+	_, err = tokenauth.ValidateToken(token.Value)
+	c.Assert(err, Equals, tokenauth.ERR_AudienceDeleted)
+
+	// Kept within retention.
+	count, err := st.PurgeAudiences(deleted.DeletedAt - 1)
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 0)
+	restored, _ := tokenauth.EnableAudience(audience.ID)
+	c.Assert(restored.DeletedAt, Equals, int64(0))
+	_, err = tokenauth.ValidateToken(token.Value)
+	c.Assert(err, IsNil)
+
+	tokenauth.SoftDeleteAudience(audience.ID)
+	count, err = st.PurgeAudiences(time.Now().Unix())
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 1)
+	saved, _ := st.GetAudience(audience.ID)
+	c.Assert(saved, IsNil)
+	saved, _ = st.GetAudience(other.ID)
+	c.Assert(saved, NotNil)
+	_, err = tokenauth.ValidateToken(token.Value)
+	c.Assert(err, Equals, tokenauth.ERR_InvalidateToken)
+}
@@ -0,0 +1,295 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package metrics exposes tokenauth validation and store metrics
+// as a prometheus Collector.
+//
+//	c := metrics.NewCollector("myapp")
+//	c.Start()
+//	defer c.Stop()
+//	tokenauth.ChangeTokenStore(c.Instrument(store, "bolt"))
+//	prometheus.MustRegister(c)
+package metrics
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ysqi/tokenauth"
+	"sync"
+	"time"
+)
+
+// Collector collects tokenauth metrics, implements prometheus.Collector.
+type Collector struct {
+	issued          *prometheus.CounterVec
+	validated       *prometheus.CounterVec
+	rejected        *prometheus.CounterVec
+	evicted         *prometheus.CounterVec
+	expired         prometheus.Counter
+	janitorDuration prometheus.Gauge
+	storeLatency    *prometheus.HistogramVec
+	liveTokens      *prometheus.Desc
+
+	mu       sync.Mutex
+	counters map[string]tokenauth.TokenCounter
+	remove   func()
+}
+
+// New collector, metric names are prefixed with namespace.
+func NewCollector(namespace string) *Collector {
+	return &Collector{
+		issued: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "tokenauth",
+			Name:      "tokens_issued_total",
+			Help:      "Count of issued tokens.",
+		}, []string{"audience"}),
+		validated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "tokenauth",
+			Name:      "tokens_validated_total",
+			Help:      "Count of tokens passed validation.",
+		}, []string{"audience"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "tokenauth",
+			Name:      "tokens_rejected_total",
+			Help:      "Count of tokens failed validation, by ValidationError code.",
+		}, []string{"code", "audience"}),
+		evicted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "tokenauth",
+			Name:      "tokens_evicted_total",
+			Help:      "Count of tokens evicted by quota or sessions.",
+		}, []string{"audience"}),
+		expired: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "tokenauth",
+			Name:      "janitor_expired_tokens_total",
+			Help:      "Count of expired tokens deleted by janitor.",
+		}),
+		janitorDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "tokenauth",
+			Name:      "janitor_sweep_duration_seconds",
+			Help:      "Duration of the last janitor sweep.",
+		}),
+		storeLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "tokenauth",
+			Name:      "store_duration_seconds",
+			Help:      "Latency of TokenStore methods.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"store", "method"}),
+		liveTokens: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "tokenauth", "live_tokens"),
+			"Count of tokens in store.",
+			[]string{"store"}, nil),
+		counters: make(map[string]tokenauth.TokenCounter),
+	}
+}
+
+// Start observing tokenauth events.
+func (c *Collector) Start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.remove == nil {
+		c.remove = tokenauth.AddHook(c.Observe,
+			tokenauth.EventTokenIssued,
+			tokenauth.EventTokenValidated,
+			tokenauth.EventTokenRejected,
+			tokenauth.EventTokenEvicted,
+			tokenauth.EventJanitorExpired,
+		)
+	}
+}
+
+// Stop observing tokenauth events.
+func (c *Collector) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.remove != nil {
+		c.remove()
+		c.remove = nil
+	}
+}
+
+// Observe one tokenauth event.
+func (c *Collector) Observe(event *tokenauth.Event) {
+	switch event.Type {
+	case tokenauth.EventTokenIssued:
+		c.issued.WithLabelValues(event.ClientID).Inc()
+	case tokenauth.EventTokenValidated:
+		c.validated.WithLabelValues(event.ClientID).Inc()
+	case tokenauth.EventTokenRejected:
+		c.rejected.WithLabelValues(event.Code, event.ClientID).Inc()
+	case tokenauth.EventTokenEvicted:
+		c.evicted.WithLabelValues(event.ClientID).Inc()
+	case tokenauth.EventJanitorExpired:
+		c.expired.Add(float64(event.Count))
+		c.janitorDuration.Set(event.Duration.Seconds())
+	}
+}
+
+// Returns store wrapper recording latencies of store methods with store label name.
+// Live tokens are collected if store is a tokenauth.TokenCounter.
+func (c *Collector) Instrument(store tokenauth.TokenStore, name string) *Store {
+	if counter, ok := store.(tokenauth.TokenCounter); ok {
+		c.mu.Lock()
+		c.counters[name] = counter
+		c.mu.Unlock()
+	}
+	return &Store{store: store, name: name, latency: c.storeLatency}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.issued.Describe(ch)
+	c.validated.Describe(ch)
+	c.rejected.Describe(ch)
+	c.evicted.Describe(ch)
+	c.expired.Describe(ch)
+	c.janitorDuration.Describe(ch)
+	c.storeLatency.Describe(ch)
+	ch <- c.liveTokens
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.issued.Collect(ch)
+	c.validated.Collect(ch)
+	c.rejected.Collect(ch)
+	c.evicted.Collect(ch)
+	c.expired.Collect(ch)
+	c.janitorDuration.Collect(ch)
+	c.storeLatency.Collect(ch)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, counter := range c.counters {
+		if count, err := counter.CountTokens(); err == nil {
+			ch <- prometheus.MustNewConstMetric(c.liveTokens, prometheus.GaugeValue, float64(count), name)
+		}
+	}
+}
+
+// Store wraps TokenStore and records latencies of all methods.
+// Optional store interfaces are always implemented,
+// methods the wrapped store does not support return error wraps tokenauth.ErrNotSupported.
+type Store struct {
+	store   tokenauth.TokenStore
+	name    string
+	latency *prometheus.HistogramVec
+}
+
+func (s *Store) observe(method string, start time.Time) {
+	s.latency.WithLabelValues(s.name, method).Observe(time.Since(start).Seconds())
+}
+
+func (s *Store) Open(config string) error {
+	defer s.observe("Open", time.Now())
+	return s.store.Open(config)
+}
+
+func (s *Store) Close() error {
+	defer s.observe("Close", time.Now())
+	return s.store.Close()
+}
+
+func (s *Store) SaveAudience(audience *tokenauth.Audience) error {
+	defer s.observe("SaveAudience", time.Now())
+	return s.store.SaveAudience(audience)
+}
+
+func (s *Store) DeleteAudience(clientID string) error {
+	defer s.observe("DeleteAudience", time.Now())
+	return s.store.DeleteAudience(clientID)
+}
+
+func (s *Store) GetAudience(clientID string) (*tokenauth.Audience, error) {
+	defer s.observe("GetAudience", time.Now())
+	return s.store.GetAudience(clientID)
+}
+
+func (s *Store) SaveToken(token *tokenauth.Token) error {
+	defer s.observe("SaveToken", time.Now())
+	return s.store.SaveToken(token)
+}
+
+func (s *Store) SaveTokenEvicted(token *tokenauth.Token) ([]string, error) {
+	defer s.observe("SaveTokenEvicted", time.Now())
+	if evicter, ok := s.store.(tokenauth.TokenEvicter); ok {
+		return evicter.SaveTokenEvicted(token)
+	}
+	return nil, s.store.SaveToken(token)
+}
+
+func (s *Store) DeleteToken(tokenString string) error {
+	defer s.observe("DeleteToken", time.Now())
+	return s.store.DeleteToken(tokenString)
+}
+
+func (s *Store) GetToken(tokenString string) (*tokenauth.Token, error) {
+	defer s.observe("GetToken", time.Now())
+	return s.store.GetToken(tokenString)
+}
+
+func (s *Store) DeleteExpired() (int, error) {
+	defer s.observe("DeleteExpired", time.Now())
+	return s.store.DeleteExpired()
+}
+
+func (s *Store) UpdateAudience(audience *tokenauth.Audience) error {
+	updater, ok := s.store.(tokenauth.AudienceUpdater)
+	if !ok {
+		return fmt.Errorf("metrics: store can not update audience: %w", tokenauth.ErrNotSupported)
+	}
+	defer s.observe("UpdateAudience", time.Now())
+	return updater.UpdateAudience(audience)
+}
+
+func (s *Store) PurgeAudiences(deletedBefore int64) (int, error) {
+	purger, ok := s.store.(tokenauth.AudiencePurger)
+	if !ok {
+		return 0, nil
+	}
+	defer s.observe("PurgeAudiences", time.Now())
+	return purger.PurgeAudiences(deletedBefore)
+}
+
+func (s *Store) ExtendToken(tokenString string, deadLine int64) error {
+	extender, ok := s.store.(tokenauth.TokenExtender)
+	if !ok {
+		return fmt.Errorf("metrics: store can not extend token: %w", tokenauth.ErrNotSupported)
+	}
+	defer s.observe("ExtendToken", time.Now())
+	return extender.ExtendToken(tokenString, deadLine)
+}
+
+func (s *Store) TouchToken(tokenString string, usedAt int64) error {
+	toucher, ok := s.store.(tokenauth.TokenToucher)
+	if !ok {
+		return nil
+	}
+	defer s.observe("TouchToken", time.Now())
+	return toucher.TouchToken(tokenString, usedAt)
+}
+
+func (s *Store) TokensOfSingle(clientID, singleID string) ([]*tokenauth.Token, error) {
+	lister, ok := s.store.(tokenauth.TokenLister)
+	if !ok {
+		return nil, fmt.Errorf("metrics: store can not list tokens: %w", tokenauth.ErrNotSupported)
+	}
+	defer s.observe("TokensOfSingle", time.Now())
+	return lister.TokensOfSingle(clientID, singleID)
+}
+
+func (s *Store) TokensOfAudience(clientID string) ([]*tokenauth.Token, error) {
+	lister, ok := s.store.(tokenauth.TokenLister)
+	if !ok {
+		return nil, fmt.Errorf("metrics: store can not list tokens: %w", tokenauth.ErrNotSupported)
+	}
+	defer s.observe("TokensOfAudience", time.Now())
+	return lister.TokensOfAudience(clientID)
+}
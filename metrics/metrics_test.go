@@ -0,0 +1,119 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metrics_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ysqi/tokenauth"
+	"github.com/ysqi/tokenauth/metrics"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type S struct{}
+
+var _ = Suite(&S{})
+
+func openBoltStore() *tokenauth.BoltDBFileStore {
+	f, _ := ioutil.TempFile("", "bolt-store-")
+	f.Close()
+	os.Remove(f.Name())
+	st := tokenauth.NewBoltDBFileStore()
+	if err := st.Open(fmt.Sprintf(`{"path":"%s"}`, f.Name())); err != nil {
+		panic(err)
+	}
+	return st
+}
+
+func (s *S) TestCollector(c *C) {
+
+	collector := metrics.NewCollector("test")
+	collector.Start()
+	defer collector.Stop()
+
+	store := collector.Instrument(openBoltStore(), "bolt")
+	tokenauth.ChangeTokenStore(store)
+	defer store.Close()
+
+	d := &tokenauth.DefaultProvider{}
+	audience, err := tokenauth.NewAudience("forTest", d.GenerateSecretString)
+	c.Assert(err, IsNil)
+	token, err := tokenauth.NewToken(audience, d.GenerateTokenString)
+	c.Assert(err, IsNil)
+	tokenauth.ValidateToken(token.Value)
+	tokenauth.ValidateToken("guess")
+	tokenauth.ValidateToken("guess")
+
+	expected := fmt.Sprintf(`
+# HELP test_tokenauth_tokens_issued_total Count of issued tokens.
+# TYPE test_tokenauth_tokens_issued_total counter
+test_tokenauth_tokens_issued_total{audience="%[1]s"} 1
+# HELP test_tokenauth_tokens_validated_total Count of tokens passed validation.
+# TYPE test_tokenauth_tokens_validated_total counter
+test_tokenauth_tokens_validated_total{audience="%[1]s"} 1
+# HELP test_tokenauth_tokens_rejected_total Count of tokens failed validation, by ValidationError code.
+# TYPE test_tokenauth_tokens_rejected_total counter
+test_tokenauth_tokens_rejected_total{audience="",code="%[2]s"} 2
+# HELP test_tokenauth_live_tokens Count of tokens in store.
+# TYPE test_tokenauth_live_tokens gauge
+test_tokenauth_live_tokens{store="bolt"} 1
+`, audience.ID, tokenauth.ERR_InvalidateToken.Code)
+
+	err = testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"test_tokenauth_tokens_issued_total",
+		"test_tokenauth_tokens_validated_total",
+		"test_tokenauth_tokens_rejected_total",
+		"test_tokenauth_live_tokens")
+	c.Assert(err, IsNil)
+
+	// GetToken called by 3 validations.
+	c.Assert(testutil.CollectAndCount(collector, "test_tokenauth_store_duration_seconds") > 0, Equals, true)
+}
+
+func (s *S) TestStore_NotSupported(c *C) {
+
+	collector := metrics.NewCollector("test")
+	store := collector.Instrument(struct{ tokenauth.TokenStore }{openBoltStore()}, "plain")
+	defer store.Close()
+
+	_, err := store.TokensOfSingle("client", "single")
+	c.Assert(errors.Is(err, tokenauth.ErrNotSupported), Equals, true)
+	_, err = store.TokensOfAudience("client")
+	c.Assert(errors.Is(err, tokenauth.ErrNotSupported), Equals, true)
+	err = store.ExtendToken("token", 0)
+	c.Assert(errors.Is(err, tokenauth.ErrNotSupported), Equals, true)
+	err = store.UpdateAudience(&tokenauth.Audience{ID: "client"})
+	c.Assert(errors.Is(err, tokenauth.ErrNotSupported), Equals, true)
+
+	// SaveTokenEvicted has own label.
+	store.SaveTokenEvicted(&tokenauth.Token{SingleID: "single", Value: "token"})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collector)
+	families, err := reg.Gather()
+	c.Assert(err, IsNil)
+	var methods []string
+	for _, f := range families {
+		if f.GetName() != "test_tokenauth_store_duration_seconds" {
+			continue
+		}
+		for _, m := range f.Metric {
+			for _, l := range m.Label {
+				if l.GetName() == "method" {
+					methods = append(methods, l.GetValue())
+				}
+			}
+		}
+	}
+	c.Assert(methods, DeepEquals, []string{"SaveTokenEvicted"})
+}
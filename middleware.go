@@ -0,0 +1,148 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+type contextKey int
+
+const tokenContextKey contextKey = 0
+
+// Returns token validated by Middleware, nil if none.
+func TokenFromContext(ctx context.Context) *Token {
+	token, _ := ctx.Value(tokenContextKey).(*Token)
+	return token
+}
+
+// Returns new context with token.
+func NewContextWithToken(ctx context.Context, token *Token) context.Context {
+	return context.WithValue(ctx, tokenContextKey, token)
+}
+
+// HTTP middleware validates token of request and puts it into request context.
+// Token is read from "Authorization: Bearer <token>" or "Authorization: DPoP <token>".
+type Middleware struct {
+	// Validate DPoP proof of "DPoP" header if not nil.
+	// DPoP scheme and DPoP bound tokens are rejected if nil.
+	DPoP *DPoPValidator
+
+	// Check token binding with ClientAttributesFromRequest if true.
+	BindClient bool
+
+	// Returns public url of request, used as DPoP htu.
+	// Default builds url from request Host and TLS state.
+	RequestURL func(r *http.Request) string
+
+	// Only tokens of these audiences are accepted if not nil.
+	Audiences []string
+
+	// Localizes error message by Accept-Language, nil is Messages.
+	Catalog *Catalog
+
+	// Writes error response, default is WriteError.
+	// err is localized.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// New middleware with default settings.
+func NewMiddleware() *Middleware {
+	return &Middleware{}
+}
+
+// Returns handler which calls next only if token of request is valid.
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := m.validate(r)
+		if err != nil {
+			m.error(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContextWithToken(r.Context(), token)))
+	})
+}
+
+func (m *Middleware) validate(r *http.Request) (*Token, error) {
+	scheme, tokenString := authorization(r)
+	if len(tokenString) == 0 {
+		return nil, ERR_TokenEmpty
+	}
+	client := ClientAttributesFromRequest(r)
+	ctx := WithClientIP(r.Context(), client.IP)
+	if m.Audiences != nil {
+		ctx = WithAudiences(ctx, m.Audiences...)
+	}
+	var attrs *ClientAttributes
+	if m.BindClient {
+		attrs = client
+	}
+
+	switch {
+	case strings.EqualFold(scheme, "DPoP"):
+		proof := r.Header.Get("DPoP")
+		if m.DPoP == nil || len(proof) == 0 {
+			return nil, ERR_DPoPProofInvalid
+		}
+		return m.DPoP.ValidateTokenContext(ctx, tokenString, proof, r.Method, m.requestURL(r), attrs)
+	case strings.EqualFold(scheme, "Bearer"):
+		return ValidateBoundTokenContext(ctx, tokenString, attrs)
+	}
+	return nil, ERR_TokenEmpty
+}
+
+func (m *Middleware) requestURL(r *http.Request) string {
+	if m.RequestURL != nil {
+		return m.RequestURL(r)
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.EscapedPath()
+}
+
+func (m *Middleware) error(w http.ResponseWriter, r *http.Request, err error) {
+	catalog := m.Catalog
+	if catalog == nil {
+		catalog = Messages
+	}
+	locale := catalog.Resolve(r.Header.Get("Accept-Language"))
+	err = catalog.Localize(err, locale)
+	w.Header().Set("Content-Language", locale)
+
+	if m.ErrorHandler != nil {
+		m.ErrorHandler(w, r, err)
+		return
+	}
+	WriteError(w, err)
+}
+
+// Writes ValidationError as JSON with HTTPStatus of err.
+// Other error is written as status text, cause is not exposed.
+func WriteError(w http.ResponseWriter, err error) {
+	status := HTTPStatus(err)
+	verr, ok := AsValidationError(err)
+	if !ok {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(verr)
+}
+
+// Returns scheme and token of Authorization header.
+func authorization(r *http.Request) (scheme, token string) {
+	auth := strings.TrimSpace(r.Header.Get("Authorization"))
+	i := strings.IndexByte(auth, ' ')
+	if i < 0 {
+		return "", ""
+	}
+	return auth[:i], strings.TrimSpace(auth[i+1:])
+}
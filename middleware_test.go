@@ -0,0 +1,94 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth_test
+
+import (
+	"encoding/json"
+	"github.com/ysqi/tokenauth"
+	. "gopkg.in/check.v1"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+func (s *S) TestMiddleware(c *C) {
+
+	k := newDPoPKey()
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret)
+	bearer, _ := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+	bound, _ := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString, tokenauth.WithDPoP(k.jkt))
+
+	m := tokenauth.NewMiddleware()
+	m.DPoP = tokenauth.NewDPoPValidator()
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(tokenauth.TokenFromContext(r.Context()).Value))
+	}))
+
+	serve := func(auth, proof string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "http://api.example.com/resource?q=1", nil)
+		if len(auth) > 0 {
+			r.Header.Set("Authorization", auth)
+		}
+		if len(proof) > 0 {
+			r.Header.Set("DPoP", proof)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	url := "http://api.example.com/resource"
+
+	w := serve("Bearer "+bearer.Value, "")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Body.String(), Equals, bearer.Value)
+
+	w = serve("DPoP "+bound.Value, k.proof("GET", url, bound.Value, time.Now()))
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Body.String(), Equals, bound.Value)
+
+	cases := []struct {
+		auth, proof string
+		err         tokenauth.ValidationError
+	}{
+		{"", "", tokenauth.ERR_TokenEmpty},
+		{"Bearer guess", "", tokenauth.ERR_InvalidateToken},
+		{"Bearer " + bound.Value, "", tokenauth.ERR_TokenBindingMismatch},
+		{"DPoP " + bound.Value, "", tokenauth.ERR_DPoPProofInvalid},
+		{"DPoP " + bound.Value, k.proof("POST", url, bound.Value, time.Now()), tokenauth.ERR_DPoPProofInvalid},
+	}
+	for i, t := range cases {
+		w = serve(t.auth, t.proof)
+		c.Check(w.Code, Equals, http.StatusUnauthorized, Commentf("case %d", i))
+		var verr tokenauth.ValidationError
+		json.Unmarshal(w.Body.Bytes(), &verr)
+		c.Check(verr, Equals, t.err, Commentf("case %d", i))
+	}
+}
+
+func (s *S) TestMiddleware_Audiences(c *C) {
+
+	mobile, _ := tokenauth.NewAudience("mobile", NewSecret)
+	partner, _ := tokenauth.NewAudience("partner", NewSecret)
+	mobileToken, _ := tokenauth.NewToken(mobile, keyPorvider.GenerateTokenString)
+	partnerToken, _ := tokenauth.NewToken(partner, keyPorvider.GenerateTokenString)
+
+	m := tokenauth.NewMiddleware()
+	m.Audiences = []string{partner.ID}
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "http://api.example.com/resource", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	c.Assert(serve(partnerToken.Value).Code, Equals, http.StatusOK)
+	w := serve(mobileToken.Value)
+	c.Assert(w.Code, Equals, http.StatusForbidden)
+	var verr tokenauth.ValidationError
+	json.Unmarshal(w.Body.Bytes(), &verr)
+	c.Assert(verr, Equals, tokenauth.ERR_TokenAudienceNotAllowed)
+}
@@ -9,6 +9,7 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -69,44 +70,140 @@ func NewObjectId() ObjectId {
 	return ObjectId(b[:])
 }
 
-// byteSlice returns byte slice of id from start to end.
-// Calling this function with an invalid id will cause a runtime panic.
-func (id ObjectId) byteSlice(start, end int) []byte {
+// Returns error if id is not exactly 12 bytes long.
+func (id ObjectId) check() error {
 	if len(id) != 12 {
-		panic(fmt.Sprintf("Invalid ObjectId: %q", string(id)))
+		return fmt.Errorf("tokenauth: invalid ObjectId %q: %w", string(id), ErrInvalidArgument)
 	}
-	return []byte(string(id)[start:end])
+	return nil
+}
+
+// Valid returns true if id is valid. A valid id must contain exactly 12 bytes.
+func (id ObjectId) Valid() bool {
+	return len(id) == 12
+}
+
+// byteSlice returns byte slice of id from start to end.
+func (id ObjectId) byteSlice(start, end int) ([]byte, error) {
+	if err := id.check(); err != nil {
+		return nil, err
+	}
+	return []byte(string(id)[start:end]), nil
 }
 
 // Time returns the timestamp part of the id.
-// It's a runtime error to call this method with an invalid id.
-func (id ObjectId) Time() time.Time {
+func (id ObjectId) Time() (time.Time, error) {
 	// First 4 bytes of ObjectId is 32-bit big-endian seconds from epoch.
-	secs := int64(binary.BigEndian.Uint32(id.byteSlice(0, 4)))
-	return time.Unix(secs, 0)
+	b, err := id.byteSlice(0, 4)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(binary.BigEndian.Uint32(b)), 0), nil
 }
 
 // Machine returns the 3-byte machine id part of the id.
-// It's a runtime error to call this method with an invalid id.
-func (id ObjectId) Machine() []byte {
+func (id ObjectId) Machine() ([]byte, error) {
 	return id.byteSlice(4, 7)
 }
 
 // Pid returns the process id part of the id.
-// It's a runtime error to call this method with an invalid id.
-func (id ObjectId) Pid() uint16 {
-	return binary.BigEndian.Uint16(id.byteSlice(7, 9))
+func (id ObjectId) Pid() (uint16, error) {
+	b, err := id.byteSlice(7, 9)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b), nil
 }
 
 // Counter returns the incrementing value part of the id.
-// It's a runtime error to call this method with an invalid id.
-func (id ObjectId) Counter() int32 {
-	b := id.byteSlice(9, 12)
+func (id ObjectId) Counter() (int32, error) {
+	b, err := id.byteSlice(9, 12)
+	if err != nil {
+		return 0, err
+	}
 	// Counter is stored as big-endian 3-byte value
-	return int32(uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2]))
+	return int32(uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])), nil
 }
 
 // Hex returns a hex representation of the ObjectId.
 func (id ObjectId) Hex() string {
 	return hex.EncodeToString([]byte(id))
 }
+
+// String returns a hex string representation of the id.
+func (id ObjectId) String() string {
+	return fmt.Sprintf("ObjectIdHex(%q)", id.Hex())
+}
+
+// ObjectIdHex returns an ObjectId from the provided hex representation.
+func ObjectIdHex(s string) (ObjectId, error) {
+	d, err := hex.DecodeString(s)
+	if err != nil || len(d) != 12 {
+		return "", fmt.Errorf("tokenauth: invalid ObjectId hex %q: %w", s, ErrInvalidArgument)
+	}
+	return ObjectId(d), nil
+}
+
+// IsObjectIdHex returns whether s is a valid hex representation of an ObjectId.
+func IsObjectIdHex(s string) bool {
+	_, err := ObjectIdHex(s)
+	return err == nil
+}
+
+// ObjectIdFromTime returns a dummy ObjectId with the timestamp part filled
+// with the provided number of seconds from epoch UTC, and all other parts
+// filled with zeroes. It's not safe to insert a document with an id generated
+// by this method, it is useful only for queries to find documents with ids
+// generated before or after the specified timestamp.
+func ObjectIdFromTime(t time.Time) ObjectId {
+	var b [12]byte
+	binary.BigEndian.PutUint32(b[:4], uint32(t.Unix()))
+	return ObjectId(b[:])
+}
+
+// MarshalJSON turns an ObjectId into a json string of its hex representation.
+// Empty id is marshaled as "".
+func (id ObjectId) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`"%x"`, string(id))), nil
+}
+
+// UnmarshalJSON turns a json string of hex representation back into an ObjectId.
+// Accepts null, "" and {"$oid": "<hex>"} too.
+func (id *ObjectId) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '{' {
+		var v struct {
+			Id json.RawMessage `json:"$oid"`
+		}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		data = v.Id
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("tokenauth: invalid ObjectId in JSON %s: %w", string(data), ErrInvalidArgument)
+	}
+	return id.UnmarshalText([]byte(s))
+}
+
+// MarshalText turns an ObjectId into text of its hex representation.
+func (id ObjectId) MarshalText() ([]byte, error) {
+	return []byte(id.Hex()), nil
+}
+
+// UnmarshalText turns hex text back into an ObjectId, empty text is empty id.
+func (id *ObjectId) UnmarshalText(data []byte) error {
+	if len(data) == 0 {
+		*id = ""
+		return nil
+	}
+	v, err := ObjectIdHex(string(data))
+	if err != nil {
+		return err
+	}
+	*id = v
+	return nil
+}
@@ -5,8 +5,11 @@
 package tokenauth_test
 
 import (
+	"encoding/json"
+	"errors"
 	"github.com/ysqi/tokenauth"
 	. "gopkg.in/check.v1"
+	"time"
 )
 
 func (s *S) TestObjectId_New(c *C) {
@@ -25,14 +28,91 @@ func (s *S) TestObjectId_New(c *C) {
 			}
 		}
 		// Check that timestamp was incremented and is within 30 seconds of the previous one
-		secs := id.Time().Sub(prevId.Time()).Seconds()
+		t, err := id.Time()
+		c.Assert(err, IsNil)
+		prevT, _ := prevId.Time()
+		secs := t.Sub(prevT).Seconds()
 		c.Assert((secs >= 0 && secs <= 30), Equals, true, Commentf("Wrong timestamp in generated ObjectId"))
 		// Check that machine ids are the same
-		c.Assert(id.Machine(), DeepEquals, prevId.Machine())
+		machine, _ := id.Machine()
+		prevMachine, _ := prevId.Machine()
+		c.Assert(machine, DeepEquals, prevMachine)
 		// Check that pids are the same
-		c.Assert(id.Pid(), Equals, prevId.Pid())
+		pid, _ := id.Pid()
+		prevPid, _ := prevId.Pid()
+		c.Assert(pid, Equals, prevPid)
 		// Test for proper increment
-		delta := int(id.Counter() - prevId.Counter())
+		counter, _ := id.Counter()
+		prevCounter, _ := prevId.Counter()
+		delta := int(counter - prevCounter)
 		c.Assert(delta, Equals, 1, Commentf("Wrong increment in generated ObjectId"))
 	}
 }
+
+func (s *S) TestObjectId_Invalid(c *C) {
+	id := tokenauth.ObjectId("short")
+	c.Assert(id.Valid(), Equals, false)
+	_, err := id.Time()
+	c.Assert(errors.Is(err, tokenauth.ErrInvalidArgument), Equals, true)
+	_, err = id.Machine()
+	c.Assert(err, NotNil)
+	_, err = id.Pid()
+	c.Assert(err, NotNil)
+	_, err = id.Counter()
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestObjectId_Hex(c *C) {
+	id := tokenauth.NewObjectId()
+	parsed, err := tokenauth.ObjectIdHex(id.Hex())
+	c.Assert(err, IsNil)
+	c.Assert(parsed, Equals, id)
+	c.Assert(tokenauth.IsObjectIdHex(id.Hex()), Equals, true)
+
+	for _, s := range []string{"", "4d88e15b60f486e428412dc", "4d88e15b60f486e428412dc9a", "4d88e15b60f486e428412dcZ"} {
+		c.Check(tokenauth.IsObjectIdHex(s), Equals, false, Commentf("%q", s))
+		_, err = tokenauth.ObjectIdHex(s)
+		c.Check(errors.Is(err, tokenauth.ErrInvalidArgument), Equals, true)
+	}
+}
+
+func (s *S) TestObjectId_FromTime(c *C) {
+	t := time.Unix(12345678, 0)
+	id := tokenauth.ObjectIdFromTime(t)
+	c.Assert(id.Hex(), Equals, "00bc614e0000000000000000")
+	idTime, err := id.Time()
+	c.Assert(err, IsNil)
+	c.Assert(idTime.Equal(t), Equals, true)
+}
+
+func (s *S) TestObjectId_JSON(c *C) {
+	type doc struct {
+		Id tokenauth.ObjectId
+	}
+	id, _ := tokenauth.ObjectIdHex("4d88e15b60f486e428412dc9")
+
+	data, err := json.Marshal(doc{Id: id})
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, `{"Id":"4d88e15b60f486e428412dc9"}`)
+
+	cases := map[string]tokenauth.ObjectId{
+		`{"Id":"4d88e15b60f486e428412dc9"}`:          id,
+		`{"Id":{"$oid":"4d88e15b60f486e428412dc9"}}`: id,
+		`{"Id":""}`:   "",
+		`{"Id":null}`: "",
+	}
+	for data, want := range cases {
+		var d doc
+		c.Check(json.Unmarshal([]byte(data), &d), IsNil, Commentf(data))
+		c.Check(d.Id, Equals, want, Commentf(data))
+	}
+	var d doc
+	c.Assert(json.Unmarshal([]byte(`{"Id":"zz"}`), &d), NotNil)
+	c.Assert(json.Unmarshal([]byte(`{"Id":12}`), &d), NotNil)
+
+	text, err := id.MarshalText()
+	c.Assert(err, IsNil)
+	var parsed tokenauth.ObjectId
+	c.Assert(parsed.UnmarshalText(text), IsNil)
+	c.Assert(parsed, Equals, id)
+}
@@ -0,0 +1,169 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Token policy of audience, saved with audience.
+// Zero fields use audience fields or store settings.
+type Policy struct {
+	// Access token lifetime, unit: seconds. 0 uses Audience.TokenPeriod.
+	AccessPeriod uint64 `json:",omitempty"`
+	// Max lifetime from issue time with SlidingExpiration, unit: seconds. 0 is unlimited.
+	RefreshPeriod uint64 `json:",omitempty"`
+	// Max live tokens of audience, evicted by Quota.Eviction. 0 uses Quota.MaxTokens.
+	MaxTokens int `json:",omitempty"`
+	// Allowed token scopes, nil allows any scope.
+	Scopes []string `json:",omitempty"`
+	// Extend token DeadLine by AccessPeriod on validation when less than half of AccessPeriod is left.
+	SlidingExpiration bool `json:",omitempty"`
+	// One live token of each SingleID, new token replaces old one.
+	SingleSession bool `json:",omitempty"`
+	// Token string format, overrides token provider of NewToken if not nil.
+	Format *TokenFormat `json:",omitempty"`
+}
+
+// Format of token string.
+type TokenFormat struct {
+	// Prefixed checksummed token, prefix is Audience.TokenPrefix.
+	// Bytes and Encoding are ignored.
+	Prefixed bool     `json:",omitempty"`
+	Bytes    int      `json:",omitempty"` // Random bytes of token, 0 is TokenBytes.
+	Encoding Encoding `json:",omitempty"`
+}
+
+// Returns new token string of format.
+func (f *TokenFormat) NewTokenString(audience *Audience) (string, error) {
+	if f.Prefixed {
+		return PrefixedTokens.NewTokenString(audience)
+	}
+	d := &DefaultProvider{TokenBytes: f.Bytes, Encoding: f.Encoding}
+	return d.NewTokenString(audience)
+}
+
+// Returned by NewToken when token scope is not allowed by audience policy.
+var ErrScopeNotAllowed = fmt.Errorf("tokenauth: scope not allowed: %w", ErrInvalidArgument)
+
+// Returns option to set token policy of audience.
+func WithPolicy(policy Policy) AudienceOption {
+	return func(audience *Audience) {
+		audience.Policy = &policy
+	}
+}
+
+// Returns option to set token scopes.
+func WithScopes(scopes ...string) TokenOption {
+	return func(token *Token) {
+		token.Scopes = scopes
+	}
+}
+
+// Optional interface implemented by stores which can change token deadline.
+type TokenExtender interface {
+
+	// Set DeadLine of saved token, time unix.
+	ExtendToken(tokenString string, deadLine int64) error
+}
+
+// Set token policy of saved audience, tokens of audience are kept.
+// Store must implement AudienceUpdater.
+func SetAudiencePolicy(clientID string, policy Policy) (*Audience, error) {
+	return updateAudience(clientID, EventAudienceSaved, func(a *Audience) {
+		a.Policy = &policy
+	})
+}
+
+// Returns policy of audience, empty policy if audience or policy is nil.
+func (a *Audience) policy() *Policy {
+	if a == nil || a.Policy == nil {
+		return &Policy{}
+	}
+	return a.Policy
+}
+
+// Returns access token lifetime, unit: seconds.
+func (a *Audience) accessPeriod() uint64 {
+	if p := a.policy(); p.AccessPeriod > 0 {
+		return p.AccessPeriod
+	}
+	return a.TokenPeriod
+}
+
+// Returns DeadLine of token issued at now, 0 if token never expires.
+func (a *Audience) deadLine(now time.Time) int64 {
+	if period := a.accessPeriod(); period > 0 {
+		return now.Unix() + int64(period)
+	}
+	return 0
+}
+
+// Returns quota with policy MaxTokens, nil if no quota.
+func (a *Audience) quota() *Quota {
+	if a == nil {
+		return nil
+	}
+	p := a.policy()
+	if p.MaxTokens <= 0 {
+		return a.Quota
+	}
+	q := Quota{}
+	if a.Quota != nil {
+		q = *a.Quota
+	}
+	q.MaxTokens = p.MaxTokens
+	return &q
+}
+
+// Returns true if all scopes are allowed by policy.
+func (p *Policy) allowScopes(scopes []string) bool {
+	if p.Scopes == nil {
+		return true
+	}
+	for _, s := range scopes {
+		allowed := false
+		for _, a := range p.Scopes {
+			if s == a {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// Extend DeadLine of token by policy SlidingExpiration, capped by RefreshPeriod.
+// Only extend when less than half of access period is left, so store is not written on each validation.
+// Does nothing if store is not TokenExtender, failure does not affect validation.
+func slideToken(ctx context.Context, audience *Audience, token *Token) {
+	p := audience.policy()
+	extender, ok := Store.(TokenExtender)
+	if !ok || !p.SlidingExpiration || token.DeadLine == 0 {
+		return
+	}
+	now := time.Now()
+	if token.DeadLine-now.Unix() >= int64(audience.accessPeriod()/2) {
+		return
+	}
+	deadLine := audience.deadLine(now)
+	if p.RefreshPeriod > 0 && token.IssuedAt > 0 {
+		if max := token.IssuedAt + int64(p.RefreshPeriod); deadLine > max {
+			deadLine = max
+		}
+	}
+	if deadLine <= token.DeadLine {
+		return
+	}
+	err := traceStore(ctx, Store, "ExtendToken", func() error { return extender.ExtendToken(token.Value, deadLine) })
+	if err == nil {
+		token.DeadLine = deadLine
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth_test
+
+import (
+	"errors"
+	"github.com/ysqi/tokenauth"
+	. "gopkg.in/check.v1"
+	"strings"
+	"time"
+)
+
+func (s *S) TestPolicy_Period(c *C) {
+
+	defer func(period uint64) { tokenauth.TokenPeriod = period }(tokenauth.TokenPeriod)
+	tokenauth.TokenPeriod = 10
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret, tokenauth.WithPolicy(tokenauth.Policy{AccessPeriod: 100}))
+	saved, _ := tokenauth.Store.GetAudience(audience.ID)
+	c.Assert(saved.Policy, DeepEquals, audience.Policy)
+
+	now := time.Now().Unix()
+	token, err := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+	c.Assert(err, IsNil)
+	c.Assert(token.IssuedAt >= now, Equals, true)
+	c.Assert(token.DeadLine, Equals, token.IssuedAt+100)
+	single, _ := tokenauth.NewSingleToken("user", audience, keyPorvider.GenerateTokenString)
+	c.Assert(single.DeadLine, Equals, single.IssuedAt+100)
+
+	// TokenPeriod is the fallback.
+	audience, _ = tokenauth.NewAudience("forTest", NewSecret, tokenauth.WithPolicy(tokenauth.Policy{}))
+	token, _ = tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+	c.Assert(token.DeadLine, Equals, token.IssuedAt+10)
+}
+
+func (s *S) TestPolicy_Scopes(c *C) {
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret,
+		tokenauth.WithPolicy(tokenauth.Policy{Scopes: []string{"read", "write"}}))
+	token, err := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString, tokenauth.WithScopes("read", "write"))
+	c.Assert(err, IsNil)
+	_, err = tokenauth.ValidateToken(token.Value)
+	c.Assert(err, IsNil)
+
+	_, err = tokenauth.NewToken(audience, keyPorvider.GenerateTokenString, tokenauth.WithScopes("admin"))
+	c.Assert(err, Equals, tokenauth.ErrScopeNotAllowed)
+	c.Assert(errors.Is(err, tokenauth.ErrInvalidArgument), Equals, true)
+
+	// Scope removed from policy.
+	_, err = tokenauth.SetAudiencePolicy(audience.ID, tokenauth.Policy{Scopes: []string{"read"}})
+	c.Assert(err, IsNil)
+	_, err = tokenauth.ValidateToken(token.Value)
+	c.Assert(err, Equals, tokenauth.ERR_TokenScopeNotAllowed)
+}
+
+func (s *S) TestPolicy_MaxTokens(c *C) {
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret, tokenauth.WithPolicy(tokenauth.Policy{MaxTokens: 1}))
+	first, _ := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+	second, err := tokenauth.NewSingleToken("user", audience, keyPorvider.GenerateTokenString)
+	c.Assert(err, IsNil)
+
+	_, err = tokenauth.ValidateToken(first.Value)
+	c.Assert(err, Equals, tokenauth.ERR_InvalidateToken)
+	_, err = tokenauth.ValidateToken(second.Value)
+	c.Assert(err, IsNil)
+}
+
+func (s *S) TestPolicy_SingleSession(c *C) {
+
+	defer useStore(newSingleStore(3, tokenauth.EvictOldest))()
+
+	multi, _ := tokenauth.NewAudience("multi", NewSecret)
+	single, _ := tokenauth.NewAudience("single", NewSecret, tokenauth.WithPolicy(tokenauth.Policy{SingleSession: true}))
+	for i := 0; i < 3; i++ {
+		tokenauth.NewSingleToken("user", multi, keyPorvider.GenerateTokenString)
+		tokenauth.NewSingleToken("user", single, keyPorvider.GenerateTokenString)
+	}
+	tokens, _ := tokenauth.SingleSessions(multi.ID, "user")
+	c.Assert(len(tokens), Equals, 3)
+	tokens, _ = tokenauth.SingleSessions(single.ID, "user")
+	c.Assert(len(tokens), Equals, 1)
+}
+
+func (s *S) TestPolicy_Format(c *C) {
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret,
+		tokenauth.WithPolicy(tokenauth.Policy{Format: &tokenauth.TokenFormat{Bytes: 8, Encoding: tokenauth.EncodingHex}}))
+	token, err := tokenauth.NewToken(audience, GenerateTokenString)
+	c.Assert(err, IsNil)
+	c.Assert(len(token.Value), Equals, 16)
+
+	audience, _ = tokenauth.NewAudience("forTest", NewSecret,
+		tokenauth.WithPolicy(tokenauth.Policy{Format: &tokenauth.TokenFormat{Prefixed: true}}))
+	token, err = tokenauth.NewToken(audience, GenerateTokenString)
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(token.Value, tokenauth.DefaultTokenPrefix+"_"), Equals, true)
+	c.Assert(tokenauth.VerifyTokenChecksum(token.Value), Equals, true)
+}
+
+func (s *S) TestPolicy_SlidingExpiration(c *C) {
+
+	st := openBoltStore()
+	defer useStore(st)()
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret,
+		tokenauth.WithPolicy(tokenauth.Policy{AccessPeriod: 100, SlidingExpiration: true}))
+	token, _ := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+	c.Assert(st.ExtendToken(token.Value, time.Now().Unix()+2), IsNil)
+
+	now := time.Now().Unix()
+	checked, err := tokenauth.ValidateToken(token.Value)
+	c.Assert(err, IsNil)
+	c.Assert(checked.DeadLine >= now+100, Equals, true)
+	saved, _ := st.GetToken(token.Value)
+	c.Assert(saved.DeadLine, Equals, checked.DeadLine)
+
+	// Not extended while more than half of access period is left.
+	deadLine := time.Now().Unix() + 60
+	c.Assert(st.ExtendToken(token.Value, deadLine), IsNil)
+	checked, err = tokenauth.ValidateToken(token.Value)
+	c.Assert(err, IsNil)
+	c.Assert(checked.DeadLine, Equals, deadLine)
+
+	// Capped by refresh period.
+	tokenauth.SetAudiencePolicy(audience.ID, tokenauth.Policy{AccessPeriod: 100, RefreshPeriod: 50, SlidingExpiration: true})
+	st.ExtendToken(token.Value, time.Now().Unix()+2)
+	checked, _ = tokenauth.ValidateToken(token.Value)
+	c.Assert(checked.DeadLine, Equals, token.IssuedAt+50)
+}
@@ -0,0 +1,104 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"fmt"
+)
+
+// Provider of audience secret string.
+type SecretProvider interface {
+	NewSecret(clientID string) (string, error)
+}
+
+// Provider of token string.
+type TokenProvider interface {
+	NewTokenString(audience *Audience) (string, error)
+}
+
+// Func adapter of SecretProvider.
+type SecretProviderFunc func(clientID string) (string, error)
+
+func (f SecretProviderFunc) NewSecret(clientID string) (string, error) {
+	return f(clientID)
+}
+
+// Func adapter of TokenProvider.
+type TokenProviderFunc func(audience *Audience) (string, error)
+
+func (f TokenProviderFunc) NewTokenString(audience *Audience) (string, error) {
+	return f(audience)
+}
+
+// Adapter of SecretProvider, panic of f is returned as error.
+func (f GenerateSecretString) NewSecret(clientID string) (secret string, err error) {
+	defer recoverError(&err)
+	return f(clientID), nil
+}
+
+// Adapter of TokenProvider, panic of f is returned as error.
+func (f GenerateTokenString) NewTokenString(audience *Audience) (token string, err error) {
+	defer recoverError(&err)
+	return f(audience), nil
+}
+
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		if e, ok := r.(error); ok {
+			*err = fmt.Errorf("tokenauth: provider panic: %w", e)
+		} else {
+			*err = fmt.Errorf("tokenauth: provider panic: %v", r)
+		}
+	}
+}
+
+func (d *DefaultProvider) NewSecret(clientID string) (string, error) {
+	return NewRandomString(SecretLength)
+}
+
+func (d *DefaultProvider) NewTokenString(audience *Audience) (string, error) {
+	n := d.TokenBytes
+	if n <= 0 {
+		n = TokenBytes
+	}
+	return RandomToken(n, d.Encoding)
+}
+
+// TokenProvider of prefixed token, prefix is audience TokenPrefix
+// or DefaultTokenPrefix if empty.
+var PrefixedTokens TokenProvider = TokenProviderFunc(func(audience *Audience) (string, error) {
+	prefix := DefaultTokenPrefix
+	if audience != nil && len(audience.TokenPrefix) > 0 {
+		prefix = audience.TokenPrefix
+	}
+	return NewPrefixedToken(prefix)
+})
+
+// Returns new secret of provider, empty secret is error.
+func newSecret(secrets SecretProvider, clientID string) (string, error) {
+	if secrets == nil {
+		return "", fmt.Errorf("tokenauth: secret provider is nil: %w", ErrInvalidArgument)
+	}
+	secret, err := secrets.NewSecret(clientID)
+	if err == nil && len(secret) == 0 {
+		err = fmt.Errorf("tokenauth: secret provider returns empty secret: %w", ErrInvalidArgument)
+	}
+	return secret, err
+}
+
+// Returns new token string of provider, empty token is error.
+func newTokenString(tokens TokenProvider, audience *Audience) (string, error) {
+	if tokens == nil {
+		return "", fmt.Errorf("tokenauth: token provider is nil: %w", ErrInvalidArgument)
+	}
+	if audience == nil {
+		return "", fmt.Errorf("tokenauth: audience is nil: %w", ErrInvalidArgument)
+	}
+	token, err := tokens.NewTokenString(audience)
+	if err == nil && len(token) == 0 {
+		err = fmt.Errorf("tokenauth: token provider returns empty token: %w", ErrInvalidArgument)
+	}
+	return token, err
+}
@@ -0,0 +1,64 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth_test
+
+import (
+	"context"
+	"errors"
+	"github.com/ysqi/tokenauth"
+	. "gopkg.in/check.v1"
+	"strings"
+)
+
+func (s *S) TestProvider_Interfaces(c *C) {
+
+	provider := &tokenauth.DefaultProvider{}
+	var _ tokenauth.SecretProvider = provider
+	var _ tokenauth.TokenProvider = provider
+
+	audience, err := tokenauth.NewAudienceFrom("forTest", provider)
+	c.Assert(err, IsNil)
+	c.Assert(len(audience.Secret), Equals, tokenauth.SecretLength)
+
+	token, err := tokenauth.NewTokenFrom(context.Background(), audience, provider)
+	c.Assert(err, IsNil)
+	_, err = tokenauth.ValidateToken(token.Value)
+	c.Assert(err, IsNil)
+
+	token, err = tokenauth.NewSingleTokenFrom(context.Background(), "single", audience, tokenauth.PrefixedTokens)
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(token.Value, tokenauth.DefaultTokenPrefix+"_"), Equals, true)
+}
+
+func (s *S) TestProvider_Errors(c *C) {
+
+	fail := errors.New("random source fail")
+	failTokens := tokenauth.TokenProviderFunc(func(a *tokenauth.Audience) (string, error) { return "", fail })
+	failSecrets := tokenauth.SecretProviderFunc(func(clientID string) (string, error) { return "", fail })
+
+	_, err := tokenauth.NewAudienceFrom("forTest", failSecrets)
+	c.Assert(err, Equals, fail)
+	_, err = tokenauth.NewAudienceFrom("forTest", tokenauth.SecretProviderFunc(func(string) (string, error) { return "", nil }))
+	c.Assert(errors.Is(err, tokenauth.ErrInvalidArgument), Equals, true)
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret)
+	_, err = tokenauth.NewTokenFrom(context.Background(), audience, failTokens)
+	c.Assert(err, Equals, fail)
+	_, err = tokenauth.NewSingleTokenFrom(context.Background(), "single", audience, failTokens)
+	c.Assert(err, Equals, fail)
+
+	// Nil audience and panics of old func types are errors.
+	provider := &tokenauth.DefaultProvider{}
+	_, err = tokenauth.NewToken(nil, provider.GenerateTokenString)
+	c.Assert(errors.Is(err, tokenauth.ErrInvalidArgument), Equals, true)
+	_, err = tokenauth.NewToken(audience, func(a *tokenauth.Audience) string { panic(fail) })
+	c.Assert(errors.Is(err, fail), Equals, true)
+	_, err = tokenauth.NewToken(audience, nil)
+	c.Assert(err, NotNil)
+
+	bad, _ := tokenauth.NewAudience("forTest", NewSecret, tokenauth.WithTokenPrefix("Bad_Prefix"))
+	_, err = tokenauth.NewTokenFrom(context.Background(), bad, tokenauth.PrefixedTokens)
+	c.Assert(errors.Is(err, tokenauth.ErrInvalidArgument), Equals, true)
+}
@@ -0,0 +1,42 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"errors"
+)
+
+// Issuance quota of audience, enforced by store when token saved.
+// Single tokens of audience are counted too, Policy.MaxTokens overrides MaxTokens.
+type Quota struct {
+	MaxTokens int            // Max live tokens of audience, 0 is unlimited.
+	Eviction  EvictionPolicy // Policy when audience has MaxTokens live tokens, EvictOldest or RejectNew.
+	Rate      float64        // Max issued tokens per second, 0 is unlimited.
+	Burst     int            // Max tokens issued at once, 0 is 1.
+}
+
+// Returned by store when audience has max live tokens and policy is RejectNew.
+var ErrQuotaExceeded = errors.New("tokenauth: too many live tokens of audience")
+
+// Returned by store when audience issues tokens too fast.
+var ErrIssueRateExceeded = errors.New("tokenauth: token issuance rate of audience exceeded")
+
+// Option to set audience fields before audience saved.
+type AudienceOption func(audience *Audience)
+
+// Returns option to set issuance quota of audience.
+func WithQuota(quota Quota) AudienceOption {
+	return func(audience *Audience) {
+		audience.Quota = &quota
+	}
+}
+
+func (q *Quota) limitConfig() LimitConfig {
+	burst := q.Burst
+	if burst <= 0 {
+		burst = 1
+	}
+	return LimitConfig{Rate: q.Rate, Burst: burst}
+}
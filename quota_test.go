@@ -0,0 +1,72 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth_test
+
+import (
+	"errors"
+	"github.com/ysqi/tokenauth"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestQuota_EvictOldest(c *C) {
+
+	audience, err := tokenauth.NewAudience("forTest", NewSecret, tokenauth.WithQuota(tokenauth.Quota{MaxTokens: 2}))
+	c.Assert(err, IsNil)
+	saved, _ := tokenauth.Store.GetAudience(audience.ID)
+	c.Assert(saved.Quota, DeepEquals, audience.Quota)
+
+	var evicted []*tokenauth.Event
+	remove := tokenauth.AddHook(func(e *tokenauth.Event) {
+		evicted = append(evicted, e)
+	}, tokenauth.EventTokenEvicted)
+	tokens := make([]*tokenauth.Token, 3)
+	for i := range tokens {
+		tokens[i], err = tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+		c.Assert(err, IsNil)
+	}
+	remove()
+	c.Assert(len(evicted), Equals, 1)
+	c.Assert(evicted[0].TokenValue, Equals, tokens[0].Value)
+	c.Assert(evicted[0].ClientID, Equals, audience.ID)
+	_, err = tokenauth.ValidateToken(tokens[0].Value)
+	c.Assert(err, Equals, tokenauth.ERR_InvalidateToken)
+	for _, t := range tokens[1:] {
+		_, err = tokenauth.ValidateToken(t.Value)
+		c.Assert(err, IsNil)
+	}
+
+	// Saved again is rejected, quota is unchanged.
+	c.Assert(errors.Is(tokenauth.Store.SaveToken(tokens[2]), tokenauth.ErrTokenExists), Equals, true)
+	_, err = tokenauth.ValidateToken(tokens[1].Value)
+	c.Assert(err, IsNil)
+}
+
+func (s *S) TestQuota_Reject(c *C) {
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret,
+		tokenauth.WithQuota(tokenauth.Quota{MaxTokens: 1, Eviction: tokenauth.RejectNew}))
+
+	first, err := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+	c.Assert(err, IsNil)
+	_, err = tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+	c.Assert(err, Equals, tokenauth.ErrQuotaExceeded)
+
+	c.Assert(tokenauth.DeleteToken(first.Value), IsNil)
+	_, err = tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+	c.Assert(err, IsNil)
+}
+
+func (s *S) TestQuota_Rate(c *C) {
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret,
+		tokenauth.WithQuota(tokenauth.Quota{Rate: 0.001, Burst: 2}))
+
+	for i := 0; i < 2; i++ {
+		_, err := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+		c.Assert(err, IsNil)
+	}
+	_, err := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+	c.Assert(err, Equals, tokenauth.ErrIssueRateExceeded)
+}
@@ -0,0 +1,352 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/boltdb/bolt"
+	"sync"
+	"time"
+)
+
+// Rate limiter of validation requests, keyed by client IP or audience.
+// Key is limited when request rate exceeds its token bucket,
+// or it is locked out after too many failures.
+type RateLimiter interface {
+
+	// Take one request of key.
+	// Returns wait duration before key is allowed, 0 if allowed now.
+	Allow(key string) (time.Duration, error)
+
+	// Record a failed attempt of key.
+	Fail(key string) error
+
+	// Clear failures and lockout of key, e.g: unlock by administrator.
+	// Successful attempts do not clear failures, failures decay by FailureDecay.
+	Reset(key string) error
+}
+
+// Settings of limiter.
+type LimitConfig struct {
+	Rate        float64       // Requests per second of every key, 0 is unlimited.
+	Burst       int           // Max requests at once.
+	MaxFailures int           // Failures before key is locked, 0 never locks.
+	Lockout     time.Duration // First lockout, doubled by every further failure.
+	MaxLockout  time.Duration // Max lockout, 0 is unlimited.
+	// One failure is forgotten every FailureDecay, 0 never forgets.
+	FailureDecay time.Duration
+}
+
+// Returns default limiter settings:
+// 10 requests per second with burst 20, locks 1s after 5 failures up to 15 minutes,
+// one failure is forgotten every minute.
+func DefaultLimitConfig() LimitConfig {
+	return LimitConfig{Rate: 10, Burst: 20, MaxFailures: 5, Lockout: time.Second, MaxLockout: 15 * time.Minute, FailureDecay: time.Minute}
+}
+
+// Returns default settings of audience limiter:
+// 1000 requests per second with burst 2000, locks 1s after 20 failures up to 15 minutes,
+// one failure is forgotten every minute.
+// Audience key is shared by all clients of audience, so rate is much higher than client IP.
+func DefaultAudienceLimitConfig() LimitConfig {
+	return LimitConfig{Rate: 1000, Burst: 2000, MaxFailures: 20, Lockout: time.Second, MaxLockout: 15 * time.Minute, FailureDecay: time.Minute}
+}
+
+type limitState struct {
+	Tokens      float64 `json:"t"`
+	Last        int64   `json:"l"` // last refill, unix nano.
+	Failures    int     `json:"f"`
+	FailedAt    int64   `json:"d,omitempty"` // last failure decay, unix nano.
+	LockedUntil int64   `json:"u"`           // unix nano.
+}
+
+func (cfg *LimitConfig) newState(now time.Time) *limitState {
+	return &limitState{Tokens: float64(cfg.Burst), Last: now.UnixNano()}
+}
+
+func (cfg *LimitConfig) allow(st *limitState, now time.Time) time.Duration {
+	n := now.UnixNano()
+	if st.LockedUntil > n {
+		return time.Duration(st.LockedUntil - n)
+	}
+	if cfg.Rate <= 0 {
+		return 0
+	}
+	st.Tokens += cfg.Rate * float64(n-st.Last) / float64(time.Second)
+	if st.Tokens > float64(cfg.Burst) {
+		st.Tokens = float64(cfg.Burst)
+	}
+	st.Last = n
+	if st.Tokens < 1 {
+		return time.Duration((1 - st.Tokens) / cfg.Rate * float64(time.Second))
+	}
+	st.Tokens--
+	return 0
+}
+
+// Forget failures older than FailureDecay.
+func (cfg *LimitConfig) decay(st *limitState, now time.Time) {
+	if cfg.FailureDecay <= 0 || st.Failures == 0 {
+		return
+	}
+	n := (now.UnixNano() - st.FailedAt) / int64(cfg.FailureDecay)
+	if n <= 0 {
+		return
+	}
+	if n >= int64(st.Failures) {
+		st.Failures, st.FailedAt = 0, 0
+		return
+	}
+	st.Failures -= int(n)
+	st.FailedAt += n * int64(cfg.FailureDecay)
+}
+
+func (cfg *LimitConfig) fail(st *limitState, now time.Time) {
+	cfg.decay(st, now)
+	if st.Failures == 0 {
+		st.FailedAt = now.UnixNano()
+	}
+	st.Failures++
+	if cfg.MaxFailures <= 0 || st.Failures < cfg.MaxFailures {
+		return
+	}
+	lockout := cfg.Lockout
+	for i := cfg.MaxFailures; i < st.Failures && (cfg.MaxLockout <= 0 || lockout < cfg.MaxLockout); i++ {
+		lockout *= 2
+	}
+	if cfg.MaxLockout > 0 && lockout > cfg.MaxLockout {
+		lockout = cfg.MaxLockout
+	}
+	st.LockedUntil = now.Add(lockout).UnixNano()
+}
+
+// Returns true if state equals a new state, can be dropped.
+func (cfg *LimitConfig) idle(st *limitState, now time.Time) bool {
+	cfg.decay(st, now)
+	if st.Failures > 0 || st.LockedUntil > now.UnixNano() {
+		return false
+	}
+	return cfg.Rate <= 0 || st.Tokens+cfg.Rate*float64(now.UnixNano()-st.Last)/float64(time.Second) >= float64(cfg.Burst)
+}
+
+// In-memory limiter.
+type MemoryLimiter struct {
+	LimitConfig
+
+	mu     sync.Mutex
+	states map[string]*limitState
+	next   time.Time
+}
+
+func NewMemoryLimiter(cfg LimitConfig) *MemoryLimiter {
+	return &MemoryLimiter{LimitConfig: cfg, states: make(map[string]*limitState)}
+}
+
+func (m *MemoryLimiter) state(key string, now time.Time) *limitState {
+	// Drop idle states at most once a minute.
+	if now.After(m.next) {
+		for k, st := range m.states {
+			if m.idle(st, now) {
+				delete(m.states, k)
+			}
+		}
+		m.next = now.Add(time.Minute)
+	}
+	st := m.states[key]
+	if st == nil {
+		st = m.newState(now)
+		m.states[key] = st
+	}
+	return st
+}
+
+func (m *MemoryLimiter) Allow(key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	return m.allow(m.state(key, now), now), nil
+}
+
+func (m *MemoryLimiter) Fail(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.fail(m.state(key, now), now)
+	return nil
+}
+
+func (m *MemoryLimiter) Reset(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if st := m.states[key]; st != nil {
+		st.Failures, st.FailedAt, st.LockedUntil = 0, 0, 0
+	}
+	return nil
+}
+
+// Limit states save in this bucket, key is limiter key, value is json state.
+var buckert_ratelimits = []byte("bk_rate_limits")
+
+// Limiter saves states in db of BoltDBFileStore,
+// states are shared by all processes use the same file.
+type BoltLimiter struct {
+	LimitConfig
+	store *BoltDBFileStore
+}
+
+// New limiter use db of opened store.
+// Limiter does not own db.
+func NewBoltLimiter(store *BoltDBFileStore, cfg LimitConfig) *BoltLimiter {
+	if store == nil {
+		panic("tokenauth: limiter store is nil")
+	}
+	return &BoltLimiter{LimitConfig: cfg, store: store}
+}
+
+// Load state of key, call fn and save state in one transaction.
+func (l *BoltLimiter) update(key string, fn func(st *limitState, now time.Time)) error {
+	return l.store.update(func(tx *bolt.Tx) error {
+		bk, err := tx.CreateBucketIfNotExists(buckert_ratelimits)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		st := l.newState(now)
+		if data := bk.Get([]byte(key)); data != nil {
+			if err = json.Unmarshal(data, st); err != nil {
+				return err
+			}
+		}
+		fn(st, now)
+		if l.idle(st, now) {
+			return bk.Delete([]byte(key))
+		}
+		data, err := json.Marshal(st)
+		if err != nil {
+			return err
+		}
+		return bk.Put([]byte(key), data)
+	})
+}
+
+func (l *BoltLimiter) Allow(key string) (wait time.Duration, err error) {
+	err = l.update(key, func(st *limitState, now time.Time) {
+		wait = l.allow(st, now)
+	})
+	return
+}
+
+func (l *BoltLimiter) Fail(key string) error {
+	return l.update(key, l.fail)
+}
+
+func (l *BoltLimiter) Reset(key string) error {
+	return l.update(key, func(st *limitState, now time.Time) {
+		st.Failures, st.FailedAt, st.LockedUntil = 0, 0, 0
+	})
+}
+
+// Limiters used by validation, default nil does not limit.
+var limiter, audienceLimiter RateLimiter
+
+// Use l to limit validation requests of client IP, nil disables limit.
+// Client IP is set by WithClientIP or Middleware,
+// validation without client IP, e.g: ValidateToken, is not limited by l.
+func SetRateLimiter(l RateLimiter) {
+	limiter = l
+}
+
+// Use l to limit validation requests and secret checks of audience, nil disables limit.
+// Key is shared by all clients of audience, use config like DefaultAudienceLimitConfig.
+// Failures are only recorded by VerifyAudienceSecret, unknown token of validation has no audience.
+func SetAudienceRateLimiter(l RateLimiter) {
+	audienceLimiter = l
+}
+
+type clientIPKey struct{}
+
+// Returns new context with client IP, which is used as limiter key.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+func clientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+func ipLimitKey(ip string) string {
+	if len(ip) == 0 {
+		return ""
+	}
+	return "ip:" + ip
+}
+
+func audienceLimitKey(clientID string) string {
+	return "audience:" + clientID
+}
+
+// Returns RateLimited error if key is limited by l.
+func limitAllow(l RateLimiter, key string) error {
+	if l == nil || len(key) == 0 {
+		return nil
+	}
+	wait, err := l.Allow(key)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		return ERR_RateLimited
+	}
+	return nil
+}
+
+// Record failed attempt of key to l.
+// Failures are invalid credentials only: unknown token or wrong audience secret,
+// policy rejections of valid credentials are not failures.
+// Success does not clear failures, so valid attempts can not hide guesses.
+func limitRecord(l RateLimiter, err error, key string) {
+	if l == nil || len(key) == 0 {
+		return
+	}
+	if errors.Is(err, ERR_InvalidateToken) || errors.Is(err, ERR_InvalidateAudienceSecret) {
+		l.Fail(key)
+	}
+}
+
+// Check secret of audience, use limiter to stop guessing.
+// Returns InvalidateAudienceSecret error if audience not found or secret not match.
+func VerifyAudienceSecret(ctx context.Context, clientID, secret string) (*Audience, error) {
+	ipKey, audKey := ipLimitKey(clientIP(ctx)), audienceLimitKey(clientID)
+	if err := limitAllow(limiter, ipKey); err != nil {
+		return nil, err
+	}
+	if err := limitAllow(audienceLimiter, audKey); err != nil {
+		return nil, err
+	}
+
+	var audience *Audience
+	err := traceStore(ctx, Store, "GetAudience", func() (err error) {
+		audience, err = Store.GetAudience(clientID)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	if audience == nil || !secureEqual(audience.Secret, secret) {
+		err = ERR_InvalidateAudienceSecret
+		audience = nil
+	}
+	limitRecord(limiter, err, ipKey)
+	limitRecord(audienceLimiter, err, audKey)
+	if err == nil {
+		if err = audience.CheckStatus(); err != nil {
+			audience = nil
+		}
+	}
+	return audience, err
+}
@@ -0,0 +1,199 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/ysqi/tokenauth"
+	. "gopkg.in/check.v1"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+func (s *S) TestRateLimit_Bucket(c *C) {
+
+	l := tokenauth.NewMemoryLimiter(tokenauth.LimitConfig{Rate: 1, Burst: 2})
+	for i := 0; i < 2; i++ {
+		wait, err := l.Allow("k")
+		c.Assert(err, IsNil)
+		c.Assert(wait, Equals, time.Duration(0))
+	}
+	wait, _ := l.Allow("k")
+	c.Assert(wait > 0 && wait <= time.Second, Equals, true)
+
+	// Other key is not affected.
+	wait, _ = l.Allow("other")
+	c.Assert(wait, Equals, time.Duration(0))
+}
+
+func testLockout(c *C, l tokenauth.RateLimiter) {
+
+	for i := 0; i < 2; i++ {
+		c.Assert(l.Fail("k"), IsNil)
+	}
+	wait, _ := l.Allow("k")
+	c.Assert(wait, Equals, time.Duration(0))
+
+	// Third failure locks 1s, fourth locks 2s.
+	l.Fail("k")
+	wait, _ = l.Allow("k")
+	c.Assert(wait > 900*time.Millisecond && wait <= time.Second, Equals, true)
+	l.Fail("k")
+	wait, _ = l.Allow("k")
+	c.Assert(wait > 1900*time.Millisecond && wait <= 2*time.Second, Equals, true)
+	for i := 0; i < 10; i++ {
+		l.Fail("k")
+	}
+	wait, _ = l.Allow("k")
+	c.Assert(wait > 4*time.Second && wait <= 5*time.Second, Equals, true)
+
+	c.Assert(l.Reset("k"), IsNil)
+	wait, _ = l.Allow("k")
+	c.Assert(wait, Equals, time.Duration(0))
+}
+
+func (s *S) TestRateLimit_Lockout(c *C) {
+	cfg := tokenauth.LimitConfig{MaxFailures: 3, Lockout: time.Second, MaxLockout: 5 * time.Second}
+	testLockout(c, tokenauth.NewMemoryLimiter(cfg))
+
+	store := openBoltStore()
+	defer store.Close()
+	testLockout(c, tokenauth.NewBoltLimiter(store, cfg))
+}
+
+func (s *S) TestRateLimit_Validate(c *C) {
+
+	tokenauth.SetRateLimiter(tokenauth.NewMemoryLimiter(tokenauth.LimitConfig{MaxFailures: 3, Lockout: time.Minute}))
+	defer tokenauth.SetRateLimiter(nil)
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret)
+	token, _ := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+
+	ctx := tokenauth.WithClientIP(context.Background(), "10.0.0.1")
+	for i := 0; i < 3; i++ {
+		_, err := tokenauth.ValidateTokenContext(ctx, "guess")
+		c.Assert(err, Equals, tokenauth.ERR_InvalidateToken)
+	}
+	_, err := tokenauth.ValidateTokenContext(ctx, token.Value)
+	c.Assert(err, Equals, tokenauth.ERR_RateLimited)
+
+	// Other client is not locked.
+	_, err = tokenauth.ValidateTokenContext(tokenauth.WithClientIP(context.Background(), "10.0.0.2"), token.Value)
+	c.Assert(err, IsNil)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("Authorization", "Bearer "+token.Value)
+	w := httptest.NewRecorder()
+	tokenauth.NewMiddleware().Handler(http.NotFoundHandler()).ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, http.StatusTooManyRequests)
+}
+
+func (s *S) TestRateLimit_Decay(c *C) {
+
+	cfg := tokenauth.LimitConfig{MaxFailures: 3, Lockout: time.Minute, FailureDecay: 100 * time.Millisecond}
+	l := tokenauth.NewMemoryLimiter(cfg)
+	l.Fail("k")
+	l.Fail("k")
+	time.Sleep(250 * time.Millisecond)
+
+	// Old failures are forgotten, key is not locked.
+	l.Fail("k")
+	l.Fail("k")
+	wait, _ := l.Allow("k")
+	c.Assert(wait, Equals, time.Duration(0))
+	l.Fail("k")
+	wait, _ = l.Allow("k")
+	c.Assert(wait > 0, Equals, true)
+}
+
+func (s *S) TestRateLimit_Validate_Interleave(c *C) {
+
+	tokenauth.SetRateLimiter(tokenauth.NewMemoryLimiter(tokenauth.LimitConfig{MaxFailures: 3, Lockout: time.Minute}))
+	defer tokenauth.SetRateLimiter(nil)
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret)
+	token, _ := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+
+	// Valid token between guesses does not clear failures.
+	ctx := tokenauth.WithClientIP(context.Background(), "10.0.0.3")
+	for i := 0; i < 3; i++ {
+		_, err := tokenauth.ValidateTokenContext(ctx, "guess")
+		c.Assert(err, Equals, tokenauth.ERR_InvalidateToken)
+		if i < 2 {
+			_, err = tokenauth.ValidateTokenContext(ctx, token.Value)
+			c.Assert(err, IsNil)
+		}
+	}
+	_, err := tokenauth.ValidateTokenContext(ctx, token.Value)
+	c.Assert(err, Equals, tokenauth.ERR_RateLimited)
+}
+
+func (s *S) TestRateLimit_Validate_Policy(c *C) {
+
+	tokenauth.SetRateLimiter(tokenauth.NewMemoryLimiter(tokenauth.LimitConfig{MaxFailures: 3, Lockout: time.Minute}))
+	defer tokenauth.SetRateLimiter(nil)
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret)
+	token, _ := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString,
+		tokenauth.WithBinding(tokenauth.Binding{IP: "10.0.0.4"}))
+
+	// Policy rejections of valid token are not failures.
+	ctx := tokenauth.WithClientIP(context.Background(), "10.0.0.4")
+	for i := 0; i < 3; i++ {
+		_, err := tokenauth.ValidateTokenContext(ctx, token.Value)
+		c.Assert(err, Equals, tokenauth.ERR_TokenBindingMismatch)
+	}
+	_, err := tokenauth.ValidateBoundTokenContext(ctx, token.Value, &tokenauth.ClientAttributes{IP: "10.0.0.4"})
+	c.Assert(err, IsNil)
+}
+
+func (s *S) TestRateLimit_Validate_Audience(c *C) {
+
+	// IP limiter does not throttle audience shared by many clients.
+	tokenauth.SetRateLimiter(tokenauth.NewMemoryLimiter(tokenauth.LimitConfig{Rate: 1, Burst: 2}))
+	defer tokenauth.SetRateLimiter(nil)
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret)
+	token, _ := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
+	for i := 0; i < 10; i++ {
+		ctx := tokenauth.WithClientIP(context.Background(), fmt.Sprintf("10.0.1.%d", i))
+		_, err := tokenauth.ValidateTokenContext(ctx, token.Value)
+		c.Assert(err, IsNil)
+	}
+
+	// Audience limiter has own config.
+	tokenauth.SetAudienceRateLimiter(tokenauth.NewMemoryLimiter(tokenauth.LimitConfig{Rate: 1, Burst: 12}))
+	defer tokenauth.SetAudienceRateLimiter(nil)
+	for i := 0; i < 12; i++ {
+		ctx := tokenauth.WithClientIP(context.Background(), fmt.Sprintf("10.0.2.%d", i))
+		_, err := tokenauth.ValidateTokenContext(ctx, token.Value)
+		c.Assert(err, IsNil)
+	}
+	_, err := tokenauth.ValidateTokenContext(tokenauth.WithClientIP(context.Background(), "10.0.3.1"), token.Value)
+	c.Assert(err, Equals, tokenauth.ERR_RateLimited)
+}
+
+func (s *S) TestRateLimit_AudienceSecret(c *C) {
+
+	tokenauth.SetAudienceRateLimiter(tokenauth.NewMemoryLimiter(tokenauth.LimitConfig{MaxFailures: 2, Lockout: time.Minute}))
+	defer tokenauth.SetAudienceRateLimiter(nil)
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret)
+	ctx := context.Background()
+
+	a, err := tokenauth.VerifyAudienceSecret(ctx, audience.ID, audience.Secret)
+	c.Assert(err, IsNil)
+	c.Assert(a.ID, Equals, audience.ID)
+
+	for i := 0; i < 2; i++ {
+		_, err = tokenauth.VerifyAudienceSecret(ctx, audience.ID, "guess")
+		c.Assert(err, Equals, tokenauth.ERR_InvalidateAudienceSecret)
+	}
+	_, err = tokenauth.VerifyAudienceSecret(ctx, audience.ID, audience.Secret)
+	c.Assert(err, Equals, tokenauth.ERR_RateLimited)
+}
@@ -0,0 +1,43 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"context"
+)
+
+type audiencesKey struct{}
+
+// Returns new context which only allows tokens of audienceIDs.
+// Validation of token whose ClientID is not in audienceIDs fails with TokenAudienceNotAllowed error.
+// No audienceIDs allows no token.
+func WithAudiences(ctx context.Context, audienceIDs ...string) context.Context {
+	ids := make(map[string]bool, len(audienceIDs))
+	for _, id := range audienceIDs {
+		ids[id] = true
+	}
+	return context.WithValue(ctx, audiencesKey{}, ids)
+}
+
+// Returns nil if ctx has no allowed audiences, or token audience is allowed.
+func checkAudience(ctx context.Context, token *Token) error {
+	ids, ok := ctx.Value(audiencesKey{}).(map[string]bool)
+	if !ok || ids[token.ClientID] {
+		return nil
+	}
+	return ERR_TokenAudienceNotAllowed
+}
+
+// Same as ValidateToken and check token is issued for one of audienceIDs.
+// Returns TokenAudienceNotAllowed error if not.
+// Bound token returns TokenBindingMismatch error.
+func ValidateTokenFor(tokenString string, audienceIDs ...string) (*Token, error) {
+	return ValidateTokenForContext(context.Background(), tokenString, audienceIDs...)
+}
+
+// Same as ValidateTokenFor, trace span is child of span in ctx.
+func ValidateTokenForContext(ctx context.Context, tokenString string, audienceIDs ...string) (*Token, error) {
+	return validateWith(WithAudiences(ctx, audienceIDs...), tokenString, nil)
+}
@@ -0,0 +1,44 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth_test
+
+import (
+	"context"
+	"github.com/ysqi/tokenauth"
+	. "gopkg.in/check.v1"
+)
+
+func (s *S) TestValidateTokenFor(c *C) {
+
+	mobile, _ := tokenauth.NewAudience("mobile", NewSecret)
+	partner, _ := tokenauth.NewAudience("partner", NewSecret)
+	token, _ := tokenauth.NewToken(mobile, keyPorvider.GenerateTokenString)
+	single, _ := tokenauth.NewSingleToken("user", mobile, keyPorvider.GenerateTokenString)
+
+	for _, t := range []*tokenauth.Token{token, single} {
+		checked, err := tokenauth.ValidateTokenFor(t.Value, partner.ID, mobile.ID)
+		c.Assert(err, IsNil)
+		c.Assert(checked.Value, Equals, t.Value)
+
+		checked, err = tokenauth.ValidateTokenFor(t.Value, partner.ID)
+		c.Assert(err, Equals, tokenauth.ERR_TokenAudienceNotAllowed)
+		c.Assert(checked, IsNil)
+
+		// No audience allows no token.
+		_, err = tokenauth.ValidateTokenFor(t.Value)
+		c.Assert(err, Equals, tokenauth.ERR_TokenAudienceNotAllowed)
+	}
+
+	// Invalid token error comes first.
+	_, err := tokenauth.ValidateTokenFor("guess", mobile.ID)
+	c.Assert(err, Equals, tokenauth.ERR_InvalidateToken)
+
+	// Restriction of context applies to other validations.
+	ctx := tokenauth.WithAudiences(context.Background(), partner.ID)
+	_, err = tokenauth.ValidateBoundTokenContext(ctx, token.Value, nil)
+	c.Assert(err, Equals, tokenauth.ERR_TokenAudienceNotAllowed)
+	_, err = tokenauth.ValidateTokenContext(ctx, token.Value)
+	c.Assert(err, Equals, tokenauth.ERR_TokenAudienceNotAllowed)
+}
@@ -0,0 +1,46 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Policy to choose which token of a SingleID is evicted
+// when the SingleID already has max live tokens.
+type EvictionPolicy int
+
+const (
+	EvictOldest            EvictionPolicy = iota // Delete the first issued token.
+	EvictLeastRecentlyUsed                       // Delete the token not validated for longest time.
+	RejectNew                                    // Keep old tokens, new token is not saved.
+)
+
+// Returned by store when SingleID has max live tokens and policy is RejectNew.
+var ErrTooManySessions = errors.New("tokenauth: too many live tokens of single id")
+
+var evictionPolicyNames = map[EvictionPolicy]string{
+	EvictOldest:            "oldest",
+	EvictLeastRecentlyUsed: "lru",
+	RejectNew:              "reject",
+}
+
+func (p EvictionPolicy) String() string {
+	if name, ok := evictionPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("EvictionPolicy(%d)", int(p))
+}
+
+// Returns policy of name: "oldest", "lru" or "reject".
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	for p, n := range evictionPolicyNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return EvictOldest, fmt.Errorf("tokenauth: unknown eviction policy %q: %w", name, ErrInvalidArgument)
+}
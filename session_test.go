@@ -0,0 +1,68 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth_test
+
+import (
+	"github.com/ysqi/tokenauth"
+	. "gopkg.in/check.v1"
+	"time"
+)
+
+func (s *S) TestSession_EvictionPolicy(c *C) {
+
+	for _, p := range []tokenauth.EvictionPolicy{tokenauth.EvictOldest, tokenauth.EvictLeastRecentlyUsed, tokenauth.RejectNew} {
+		parsed, err := tokenauth.ParseEvictionPolicy(p.String())
+		c.Assert(err, IsNil)
+		c.Assert(parsed, Equals, p)
+	}
+	_, err := tokenauth.ParseEvictionPolicy("never")
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestSession_Metadata(c *C) {
+
+	st := openBoltStore()
+	st.TouchInterval = time.Hour
+	defer useStore(st)()
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret)
+	token, err := tokenauth.NewSingleToken("user", audience, keyPorvider.GenerateTokenString,
+		tokenauth.WithSession(tokenauth.Session{Device: "iPhone", ClientIP: "10.0.0.1", UserAgent: "app/1.0"}))
+	c.Assert(err, IsNil)
+	c.Assert(token.Session, NotNil)
+	c.Assert(token.Session.CreatedAt > 0, Equals, true)
+
+	tokenauth.ValidateToken(token.Value)
+	tokens, err := tokenauth.SingleSessions(audience.ID, "user")
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 1)
+	c.Assert(tokens[0].Session.Device, Equals, "iPhone")
+	c.Assert(tokens[0].Session.ClientIP, Equals, "10.0.0.1")
+	c.Assert(tokens[0].Session.UserAgent, Equals, "app/1.0")
+	lastUsed := tokens[0].Session.LastUsed
+	c.Assert(lastUsed >= token.Session.CreatedAt, Equals, true)
+
+	// Throttled, not written again within TouchInterval.
+	st.TouchToken(token.Value, time.Now().Add(time.Minute).UnixNano())
+	tokens, _ = tokenauth.SingleSessions(audience.ID, "user")
+	c.Assert(tokens[0].Session.LastUsed, Equals, lastUsed)
+
+	st.TouchToken(token.Value, time.Now().Add(2*time.Hour).UnixNano())
+	tokens, _ = tokenauth.SingleSessions(audience.ID, "user")
+	c.Assert(tokens[0].Session.LastUsed > lastUsed, Equals, true)
+
+	multi, _ := tokenauth.NewToken(audience, keyPorvider.GenerateTokenString,
+		tokenauth.WithSession(tokenauth.Session{Device: "laptop"}))
+	tokens, err = tokenauth.AudienceSessions(audience.ID)
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 2)
+	for _, t := range tokens {
+		if t.Value == multi.Value {
+			c.Assert(t.Session.Device, Equals, "laptop")
+		} else {
+			c.Assert(t.Value, Equals, token.Value)
+		}
+	}
+}
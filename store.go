@@ -5,6 +5,7 @@
 package tokenauth
 
 import (
+	"context"
 	"fmt"
 	"runtime"
 	"time"
@@ -31,7 +32,7 @@ type TokenStore interface {
 	GetAudience(clientID string) (*Audience, error)
 
 	// Save token to token.
-	// Returns error if save token fail.
+	// Returns error if save token fail, ErrTokenExists if token string is saved.
 	SaveToken(token *Token) error
 
 	// Delete token info from store.
@@ -43,7 +44,43 @@ type TokenStore interface {
 	// Returns error if get token fail.
 	GetToken(tokenString string) (*Token, error)
 
-	DeleteExpired()
+	// Delete all expired tokens.
+	// Returns count of deleted tokens and error if delete fail.
+	DeleteExpired() (int, error)
+}
+
+// Optional interface implemented by stores which can count live tokens.
+type TokenCounter interface {
+
+	// Returns count of tokens in store, include expired but not deleted tokens.
+	CountTokens() (int, error)
+}
+
+// Optional interface implemented by stores which record when token is used.
+type TokenToucher interface {
+
+	// Record token is used at usedAt, time unix nano,
+	// and update LastUsed of token session.
+	// Store may skip the write if token was touched recently.
+	TouchToken(tokenString string, usedAt int64) error
+}
+
+// Optional interface implemented by stores which can list tokens.
+type TokenLister interface {
+
+	// Returns live tokens of SingleID in audience clientID.
+	TokensOfSingle(clientID, singleID string) ([]*Token, error)
+
+	// Returns live tokens of audience.
+	TokensOfAudience(clientID string) ([]*Token, error)
+}
+
+// Optional interface implemented by stores which evict tokens to save new token,
+// e.g: by audience quota or sessions of SingleID.
+type TokenEvicter interface {
+
+	// Same as SaveToken, also returns token strings evicted to save token.
+	SaveTokenEvicted(token *Token) (evicted []string, err error)
 }
 
 // Janitor contains TokenStore and Janitor instance.
@@ -65,7 +102,14 @@ func (j *janitor) Run(taget *janitorTaget) {
 	for {
 		select {
 		case <-tick:
-			taget.store.DeleteExpired()
+			start := time.Now()
+			var count int
+			err := traceStore(context.Background(), taget.store, "DeleteExpired", func() (err error) {
+				count, err = taget.store.DeleteExpired()
+				return
+			})
+			emit(&Event{Type: EventJanitorExpired, Time: start, Count: count, Err: err, Duration: time.Since(start)})
+			purgeAudiences(taget.store)
 		case <-j.stop:
 			return
 		}
@@ -107,7 +151,8 @@ func NewStore(adapterName, config string) (TokenStore, error) {
 	if !ok {
 		return nil, fmt.Errorf("tokenStore: unknown adapter name %q (forgot registration ?)", adapterName)
 	}
-	if err := adapter.Open(config); err != nil {
+	err := traceStore(context.Background(), adapter, "Open", func() error { return adapter.Open(config) })
+	if err != nil {
 		return nil, err
 	} else {
 		s := &janitorTaget{store: adapter}
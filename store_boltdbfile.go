@@ -5,19 +5,40 @@
 package tokenauth
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/boltdb/bolt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 )
 
+// Default count of expired tokens deleted in one write transaction.
+const DefaultExpireBatchSize = 1000
+
+// Default interval to skip repeated touch of the same token.
+const DefaultTouchInterval = time.Minute
+
+var errTokenNotFound = fmt.Errorf("boltdbStore: token %w", ErrNotFound)
+
 // Store implement by boltdb,see:https://github.com/boltdb/bolt
 type BoltDBFileStore struct {
 	Alias  string
 	db     *bolt.DB
 	dbPath string
+
+	// Max count of expired tokens deleted in one write transaction.
+	ExpireBatchSize int
+
+	// Max live tokens of one SingleID, 0 is 1.
+	MaxSingleSessions int
+	// Policy when SingleID has max live tokens.
+	SingleEviction EvictionPolicy
+	// Interval to skip repeated touch of the same token.
+	TouchInterval time.Duration
 }
 
 var (
@@ -27,9 +48,69 @@ var (
 	buckert_oneAudienceTokens = []byte("bk_one_audience_tokens")
 	// one audience info key
 	audienceInfoKey                 = []byte("one_audience")
+	// Deprecated, single token relations before single sessions bucket,
+	// migrated when db opened.
 	buckert_singletokens_singledids = []byte("bk_token_singleIDs")
+	// deadline index, key is big-endian DeadLine + token string.
+	// Tokens never expired (DeadLine=0) are not indexed.
+	buckert_token_deadlines = []byte("bk_token_deadlines")
 )
 
+// Returns deadline index key of token.
+func deadlineKey(deadLine int64, tokenString string) []byte {
+	key := make([]byte, 8+len(tokenString))
+	binary.BigEndian.PutUint64(key, uint64(deadLine))
+	copy(key[8:], tokenString)
+	return key
+}
+
+// Save token deadline index if token will expire.
+func putDeadlineIndex(token *Token, tx *bolt.Tx) error {
+	if token.DeadLine == 0 {
+		return nil
+	}
+	bk, err := tx.CreateBucketIfNotExists(buckert_token_deadlines)
+	if err != nil {
+		return err
+	}
+	return bk.Put(deadlineKey(token.DeadLine, token.Value), []byte(""))
+}
+
+// Delete token deadline index if exist.
+func deleteDeadlineIndex(token *Token, tx *bolt.Tx) error {
+	if token.DeadLine == 0 {
+		return nil
+	}
+	bk := tx.Bucket(buckert_token_deadlines)
+	if bk == nil {
+		return nil
+	}
+	return bk.Delete(deadlineKey(token.DeadLine, token.Value))
+}
+
+// Run fn in write transaction, returns ErrStoreClosed if db is not opened.
+func (store *BoltDBFileStore) update(fn func(tx *bolt.Tx) error) error {
+	if store.db == nil {
+		return ErrStoreClosed
+	}
+	return boltError(store.db.Update(fn))
+}
+
+// Run fn in read transaction, returns ErrStoreClosed if db is not opened.
+func (store *BoltDBFileStore) view(fn func(tx *bolt.Tx) error) error {
+	if store.db == nil {
+		return ErrStoreClosed
+	}
+	return boltError(store.db.View(fn))
+}
+
+func boltError(err error) error {
+	if err == bolt.ErrDatabaseNotOpen {
+		return ErrStoreClosed
+	}
+	return err
+}
+
 func (store *BoltDBFileStore) DBPath() string {
 	return store.dbPath
 }
@@ -45,6 +126,19 @@ func (store *BoltDBFileStore) deleteAudience(id string, tx *bolt.Tx) error {
 	if tokensBk != nil {
 		audienceTokensBk := bk.Bucket(buckert_oneAudienceTokens)
 		err := audienceTokensBk.ForEach(func(k, v []byte) error {
+			if tokenBytes := tokensBk.Get(k); tokenBytes != nil {
+				token := &Token{}
+				if err := json.Unmarshal(tokenBytes, token); err == nil {
+					if err = deleteDeadlineIndex(token, tx); err != nil {
+						return err
+					}
+					if token.IsSingle() {
+						if err = removeSession(token, tx); err != nil {
+							return err
+						}
+					}
+				}
+			}
 			return tokensBk.Delete(k)
 		})
 		if err != nil {
@@ -60,7 +154,7 @@ func (store *BoltDBFileStore) deleteAudience(id string, tx *bolt.Tx) error {
 func (store *BoltDBFileStore) SaveAudience(audience *Audience) error {
 
 	if audience == nil || len(audience.ID) == 0 {
-		return errors.New("audience id is empty.")
+		return fmt.Errorf("boltdbStore: audience id is empty: %w", ErrInvalidArgument)
 	}
 
 	bytes, err := json.Marshal(audience)
@@ -68,7 +162,7 @@ func (store *BoltDBFileStore) SaveAudience(audience *Audience) error {
 		return err
 	}
 
-	return store.db.Update(func(tx *bolt.Tx) error {
+	return store.update(func(tx *bolt.Tx) error {
 		// need delete old audience info before save
 		if err := store.deleteAudience(audience.ID, tx); err != nil {
 			return err
@@ -92,10 +186,10 @@ func (store *BoltDBFileStore) SaveAudience(audience *Audience) error {
 // Delete audience and  all tokens of audience.
 func (store *BoltDBFileStore) DeleteAudience(audienceID string) error {
 	if len(audienceID) == 0 {
-		return errors.New("audienceID is emtpty.")
+		return fmt.Errorf("boltdbStore: audience id is empty: %w", ErrInvalidArgument)
 	}
 
-	return store.db.Update(func(tx *bolt.Tx) error {
+	return store.update(func(tx *bolt.Tx) error {
 		return store.deleteAudience(audienceID, tx)
 	})
 }
@@ -104,10 +198,10 @@ func (store *BoltDBFileStore) DeleteAudience(audienceID string) error {
 func (store *BoltDBFileStore) GetAudience(audienceID string) (audience *Audience, err error) {
 
 	if len(audienceID) == 0 {
-		return nil, errors.New("audienceID is emtpty.")
+		return nil, fmt.Errorf("boltdbStore: audience id is empty: %w", ErrInvalidArgument)
 	}
 
-	err = store.db.View(func(tx *bolt.Tx) error {
+	err = store.view(func(tx *bolt.Tx) error {
 		bk := tx.Bucket([]byte(audienceID))
 		// not found
 		if bk == nil {
@@ -131,60 +225,70 @@ func (store *BoltDBFileStore) GetAudience(audienceID string) (audience *Audience
 }
 
 // Save token to store. return error when save fail.
-// Save token json to store and save the relation of token with client if not single model.
+// Save token json to store, the relation of token with client if token has ClientID,
+// and the session of token if single model.
+// Single token of audience not stored is saved without relation.
 // The first , token must not empty and effectiveness.
-// Does not consider concurrency.
+// Returns ErrTokenExists if token string is saved, existing token is not changed.
 func (store *BoltDBFileStore) SaveToken(token *Token) error {
+	_, err := store.SaveTokenEvicted(token)
+	return err
+}
+
+// Same as SaveToken, also returns token strings evicted by quota or sessions of SingleID.
+func (store *BoltDBFileStore) SaveTokenEvicted(token *Token) ([]string, error) {
 	if token == nil || len(token.Value) == 0 {
-		return errors.New("token tokenString is empty.")
+		return nil, fmt.Errorf("boltdbStore: token string is empty: %w", ErrInvalidArgument)
 	}
 	if len(token.ClientID) == 0 && len(token.SingleID) == 0 {
-		return errors.New("token clientid and singleid,It can't be empty")
+		return nil, fmt.Errorf("boltdbStore: token clientid and singleid are empty: %w", ErrInvalidArgument)
 	}
 	if token.Expired() {
-		return errors.New("token is expired,not need save.")
+		return nil, fmt.Errorf("boltdbStore: token is expired: %w", ErrInvalidArgument)
 	}
 
 	//first to get token byte data
 	tokenBytes, err := json.Marshal(token)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return store.db.Update(func(tx *bolt.Tx) error {
+	var evicted []string
+	err = store.update(func(tx *bolt.Tx) error {
+		evicted = nil
 
 		bk, err := tx.CreateBucketIfNotExists(buckert_alltokens)
 		if err != nil {
 			return err
 		}
+		if bk.Get([]byte(token.Value)) != nil {
+			return ErrTokenExists
+		}
 
-		// Singlge token has no client. But need delete old token
-		if token.IsSingle() {
-			if idsBK, err := tx.CreateBucketIfNotExists(buckert_singletokens_singledids); err != nil {
+		// Need add token key to client blucket.
+		// Only save the relation of token with client.
+		// Relation value is issued time, used to evict oldest token by quota.
+		// Single token of audience not stored has no relation, e.g: NewAudienceNotStore.
+		var audience *Audience
+		var au *bolt.Bucket
+		if len(token.ClientID) > 0 {
+			au = tx.Bucket([]byte(token.ClientID))
+		}
+		if au == nil && len(token.ClientID) > 0 && !token.IsSingle() {
+			return fmt.Errorf("boltdbStore: save audience %s before token: %w", token.ClientID, ErrAudienceNotFound)
+		}
+		if au != nil {
+			if audience, err = audienceInfo(au); err != nil {
+				return err
+			} else if err = store.applyQuota(audience, au, tx, &evicted); err != nil {
+				return err
+			} else if err = au.Bucket(buckert_oneAudienceTokens).Put([]byte(token.Value), sessionValue(time.Now().UnixNano())); err != nil {
 				return err
-			} else {
-				key := []byte(token.SingleID)
-
-				// Find and delete old token.
-				oldTokenValueData := idsBK.Get(key)
-				if oldTokenValueData != nil {
-					if err = store.deleteToken(string(oldTokenValueData), tx); err != nil {
-						return err
-					}
-				}
-
-				// Save new token relation
-				if err = idsBK.Put(key, []byte(token.Value)); err != nil {
-					return err
-				}
 			}
-
-		} else {
-			// Need add token key to client blucket.
-			// Only save the relation of token with client.
-			if au := tx.Bucket([]byte(token.ClientID)); au == nil {
-				return errors.New("can not found audience, not save audience before save token ?")
-			} else if err = au.Bucket(buckert_oneAudienceTokens).Put([]byte(token.Value), []byte("")); err != nil {
+		}
+		// Need delete old tokens if SingleID has max live tokens.
+		if token.IsSingle() {
+			if err = store.addSession(token, audience, tx, &evicted); err != nil {
 				return err
 			}
 		}
@@ -192,38 +296,63 @@ func (store *BoltDBFileStore) SaveToken(token *Token) error {
 		if err != nil {
 			return err
 		}
+
+		if err = putDeadlineIndex(token, tx); err != nil {
+			return err
+		}
 		err = bk.Put([]byte(token.Value), tokenBytes)
 		return err
 
 	})
+	if err != nil {
+		return nil, err
+	}
+	return evicted, nil
 }
 
 //Get token info if find in store,or return error
 func (store *BoltDBFileStore) GetToken(tokenString string) (token *Token, err error) {
 	if len(tokenString) == 0 {
-		return nil, errors.New("tokenString is empty.")
+		return nil, fmt.Errorf("boltdbStore: token string is empty: %w", ErrInvalidArgument)
 	}
 
-	err = store.db.View(func(tx *bolt.Tx) error {
+	err = store.view(func(tx *bolt.Tx) error {
+		token, err = getToken(tokenString, tx)
+		return err
+	})
 
-		bk := tx.Bucket(buckert_alltokens)
-		if bk == nil {
-			return nil
-		}
-		tokenBytes := bk.Get([]byte(tokenString))
-		if tokenBytes == nil {
-			return nil
-		}
+	return
+}
 
-		token = &Token{}
-		if err := json.Unmarshal(tokenBytes, token); err != nil {
-			token = nil
-			return err
-		} else {
-			return nil
+// Get token info in tx, returns nil if not found.
+func getToken(tokenString string, tx *bolt.Tx) (*Token, error) {
+	bk := tx.Bucket(buckert_alltokens)
+	if bk == nil {
+		return nil, nil
+	}
+	tokenBytes := bk.Get([]byte(tokenString))
+	if tokenBytes == nil {
+		return nil, nil
+	}
+
+	token := &Token{}
+	if err := json.Unmarshal(tokenBytes, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Returns count of saved tokens.
+func (store *BoltDBFileStore) CountTokens() (count int, err error) {
+	if store.db == nil {
+		return 0, nil
+	}
+	err = store.view(func(tx *bolt.Tx) error {
+		if bk := tx.Bucket(buckert_alltokens); bk != nil {
+			count = bk.Stats().KeyN
 		}
+		return nil
 	})
-
 	return
 }
 
@@ -232,10 +361,10 @@ func (store *BoltDBFileStore) GetToken(tokenString string) (token *Token, err er
 func (store *BoltDBFileStore) DeleteToken(tokenString string) error {
 
 	if len(tokenString) == 0 {
-		return errors.New("incompatible tokenString")
+		return fmt.Errorf("boltdbStore: token string is empty: %w", ErrInvalidArgument)
 	}
 
-	return store.db.Update(func(tx *bolt.Tx) error {
+	return store.update(func(tx *bolt.Tx) error {
 		return store.deleteToken(tokenString, tx)
 	})
 }
@@ -245,14 +374,14 @@ func (store *BoltDBFileStore) DeleteToken(tokenString string) error {
 func (store *BoltDBFileStore) deleteToken(tokenString string, tx *bolt.Tx) error {
 	bk := tx.Bucket(buckert_alltokens)
 	if bk == nil {
-		return errors.New("incompatible tokenString")
+		return errTokenNotFound
 	}
 
 	key := []byte(tokenString)
 	tokenBytes := bk.Get(key)
 	// Not found
 	if tokenBytes == nil {
-		return errors.New("incompatible tokenString")
+		return errTokenNotFound
 	}
 
 	err := bk.Delete(key)
@@ -263,8 +392,16 @@ func (store *BoltDBFileStore) deleteToken(tokenString string, tx *bolt.Tx) error
 	//clear the relation token with client
 	token := &Token{}
 	err = json.Unmarshal(tokenBytes, token)
-	if err == nil && token.IsSingle() == false {
-		err = tx.Bucket([]byte(token.ClientID)).Bucket(buckert_oneAudienceTokens).Delete(key)
+	if err == nil {
+		err = deleteDeadlineIndex(token, tx)
+	}
+	if err == nil && token.IsSingle() {
+		err = removeSession(token, tx)
+	}
+	if err == nil && len(token.ClientID) > 0 {
+		if au := tx.Bucket([]byte(token.ClientID)); au != nil {
+			err = au.Bucket(buckert_oneAudienceTokens).Delete(key)
+		}
 	}
 	return err
 }
@@ -297,14 +434,42 @@ func (store *BoltDBFileStore) open(dbPath string) error {
 			err = store.db.Close()
 			if err != nil {
 				db.Close() //need close new db
-				return errors.New("store: close old db fail," + err.Error())
+				return fmt.Errorf("boltdbStore: close old db fail: %w", err)
 			}
 		}
 		store.db = db
 		store.dbPath = db.Path()
 	}
 
-	return nil
+	return store.update(func(tx *bolt.Tx) error {
+		if err := buildDeadlineIndex(tx); err != nil {
+			return err
+		}
+		return migrateSingleIDs(tx)
+	})
+}
+
+// Build deadline index for db created before the index existed.
+// Does nothing if the index bucket exists.
+func buildDeadlineIndex(tx *bolt.Tx) error {
+	if tx.Bucket(buckert_token_deadlines) != nil {
+		return nil
+	}
+	if _, err := tx.CreateBucket(buckert_token_deadlines); err != nil {
+		return err
+	}
+	bk := tx.Bucket(buckert_alltokens)
+	if bk == nil {
+		return nil
+	}
+	return bk.ForEach(func(k, v []byte) error {
+		token := &Token{}
+		if err := json.Unmarshal(v, token); err != nil {
+			// skip broken token data
+			return nil
+		}
+		return putDeadlineIndex(token, tx)
+	})
 }
 
 // Close bolt db
@@ -315,32 +480,84 @@ func (store *BoltDBFileStore) Close() error {
 	return nil
 }
 
-// Delete token if token expired
-func (store *BoltDBFileStore) DeleteExpired() {
+// Delete tokens which expired.
+// Walk deadline index and delete due tokens in write transactions of
+// ExpireBatchSize tokens, until no due token left.
+// Returns count of deleted tokens and the first error.
+func (store *BoltDBFileStore) DeleteExpired() (int, error) {
 
 	if store.db == nil {
-		return
+		return 0, nil
 	}
 
-	store.db.View(func(tx *bolt.Tx) error {
-		// Get all tokens bucket.
-		bk := tx.Bucket(buckert_alltokens)
-		if bk == nil {
+	batchSize := store.ExpireBatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultExpireBatchSize
+	}
+
+	total := 0
+	for {
+		scanned, deleted, err := store.deleteExpiredBatch(time.Now().Unix(), batchSize)
+		total += deleted
+		if err != nil || scanned < batchSize {
+			return total, err
+		}
+	}
+}
+
+// Delete at most batchSize tokens which deadline <= now in one write transaction.
+// Returns count of walked index entries and count of deleted tokens.
+func (store *BoltDBFileStore) deleteExpiredBatch(now int64, batchSize int) (scanned, deleted int, err error) {
+
+	err = store.update(func(tx *bolt.Tx) error {
+		idx := tx.Bucket(buckert_token_deadlines)
+		if idx == nil {
 			return nil
 		}
-		// Foreach all tokens.
-		bk.ForEach(func(k, v []byte) error {
+
+		// Collect due keys first, then delete them.
+		max := deadlineKey(now, "")
+		keys := make([][]byte, 0, batchSize)
+		c := idx.Cursor()
+		for k, _ := c.First(); k != nil && len(keys) < batchSize; k, _ = c.Next() {
+			if bytes.Compare(k[:8], max) > 0 {
+				break
+			}
+			keys = append(keys, append([]byte(nil), k...))
+		}
+
+		tokensBk := tx.Bucket(buckert_alltokens)
+		for _, k := range keys {
+			scanned++
+			if err := idx.Delete(k); err != nil {
+				return err
+			}
+			if tokensBk == nil {
+				continue
+			}
+			tokenString := string(k[8:])
+			tokenBytes := tokensBk.Get(k[8:])
+			if tokenBytes == nil {
+				continue
+			}
 			token := &Token{}
-			if err := json.Unmarshal(v, token); err == nil {
-				// Will delete token when expired
-				if token.Expired() {
-					store.DeleteToken(token.Value)
-				}
+			if err := json.Unmarshal(tokenBytes, token); err != nil {
+				return err
 			}
-			return nil
-		})
+			// Stale index, token was saved again with other deadline.
+			if !token.Expired() {
+				continue
+			}
+			if err := store.deleteToken(tokenString, tx); err != nil {
+				return err
+			}
+			deleted++
+		}
 		return nil
 	})
+	if err != nil {
+		scanned, deleted = 0, 0
+	}
 	return
 
 }
@@ -349,20 +566,48 @@ func (store *BoltDBFileStore) DeleteExpired() {
 // config is json string.
 // e.g:
 //  {"path":"./data/tokenbolt.db"}
+// Optional "expireBatchSize" key limits expired tokens deleted per transaction.
+// Optional "maxSingleSessions" and "singleEviction" ("oldest","lru","reject")
+// keys allow N live tokens of one SingleID.
+//  {"path":"./data/tokenbolt.db","expireBatchSize":"500","maxSingleSessions":"3","singleEviction":"lru"}
 func (store *BoltDBFileStore) Open(config string) error {
 
 	if len(config) == 0 {
-		return errors.New("boltdbStore: bolt db store config is empty")
+		return fmt.Errorf("boltdbStore: bolt db store config is empty: %w", ErrInvalidArgument)
 	}
 
 	var cf map[string]string
 
 	if err := json.Unmarshal([]byte(config), &cf); err != nil {
-		return fmt.Errorf("boltdbStore: unmarshal %p fail:%s", config, err.Error())
+		return fmt.Errorf("boltdbStore: unmarshal %s fail: %w", config, err)
+	}
+
+	if size, ok := cf["expireBatchSize"]; ok {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			return fmt.Errorf("boltdbStore: invalid expireBatchSize %q: %w", size, ErrInvalidArgument)
+		}
+		store.ExpireBatchSize = n
+	}
+
+	if max, ok := cf["maxSingleSessions"]; ok {
+		n, err := strconv.Atoi(max)
+		if err != nil {
+			return fmt.Errorf("boltdbStore: invalid maxSingleSessions %q: %w", max, ErrInvalidArgument)
+		}
+		store.MaxSingleSessions = n
+	}
+
+	if name, ok := cf["singleEviction"]; ok {
+		policy, err := ParseEvictionPolicy(name)
+		if err != nil {
+			return fmt.Errorf("boltdbStore: %w", err)
+		}
+		store.SingleEviction = policy
 	}
 
 	if path, ok := cf["path"]; !ok {
-		return errors.New("boltdbStore: bolt db store config has no path key.")
+		return fmt.Errorf("boltdbStore: bolt db store config has no path key: %w", ErrInvalidArgument)
 	} else {
 		return store.open(path)
 	}
@@ -372,7 +617,7 @@ func (store *BoltDBFileStore) Open(config string) error {
 // new Bolt DB file store instance.
 func NewBoltDBFileStore() *BoltDBFileStore {
 
-	return &BoltDBFileStore{Alias: "BoltDBFileStore"}
+	return &BoltDBFileStore{Alias: "BoltDBFileStore", TouchInterval: DefaultTouchInterval}
 }
 
 func init() {
@@ -0,0 +1,67 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// Save audience info, tokens of audience are kept.
+// Returns error wraps ErrAudienceNotFound if audience is not saved.
+func (store *BoltDBFileStore) UpdateAudience(audience *Audience) error {
+	if audience == nil || len(audience.ID) == 0 {
+		return fmt.Errorf("boltdbStore: audience id is empty: %w", ErrInvalidArgument)
+	}
+	bytes, err := json.Marshal(audience)
+	if err != nil {
+		return err
+	}
+	return store.update(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte(audience.ID))
+		if bk == nil || bk.Get(audienceInfoKey) == nil {
+			return fmt.Errorf("boltdbStore: audience %s: %w", audience.ID, ErrAudienceNotFound)
+		}
+		return bk.Put(audienceInfoKey, bytes)
+	})
+}
+
+// Delete audiences and their tokens which are soft deleted before deletedBefore, time unix.
+// Returns count of deleted audiences.
+func (store *BoltDBFileStore) PurgeAudiences(deletedBefore int64) (count int, err error) {
+	if store.db == nil {
+		return 0, nil
+	}
+	err = store.update(func(tx *bolt.Tx) error {
+		var ids []string
+		err := tx.ForEach(func(name []byte, bk *bolt.Bucket) error {
+			data := bk.Get(audienceInfoKey)
+			if data == nil || bk.Bucket(buckert_oneAudienceTokens) == nil {
+				return nil
+			}
+			audience := &Audience{}
+			if err := json.Unmarshal(data, audience); err != nil {
+				// skip broken audience data
+				return nil
+			}
+			if audience.Status == AudienceDeleted && audience.DeletedAt <= deletedBefore {
+				ids = append(ids, string(name))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err = store.deleteAudience(id, tx); err != nil {
+				return err
+			}
+		}
+		count = len(ids)
+		return nil
+	})
+	return
+}
@@ -0,0 +1,40 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// Set DeadLine of saved token, time unix, and move its deadline index.
+// Returns error wraps ErrNotFound if token is not saved.
+func (store *BoltDBFileStore) ExtendToken(tokenString string, deadLine int64) error {
+	if len(tokenString) == 0 {
+		return fmt.Errorf("boltdbStore: token string is empty: %w", ErrInvalidArgument)
+	}
+	return store.update(func(tx *bolt.Tx) error {
+		token, err := getToken(tokenString, tx)
+		if err != nil {
+			return err
+		}
+		if token == nil {
+			return errTokenNotFound
+		}
+		if err = deleteDeadlineIndex(token, tx); err != nil {
+			return err
+		}
+		token.DeadLine = deadLine
+		if err = putDeadlineIndex(token, tx); err != nil {
+			return err
+		}
+		data, err := json.Marshal(token)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(buckert_alltokens).Put([]byte(token.Value), data)
+	})
+}
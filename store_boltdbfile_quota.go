@@ -0,0 +1,137 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/boltdb/bolt"
+	"time"
+)
+
+// Issuance rate state of audience, json limit state saved in audience bucket.
+var issueRateKey = []byte("issue_rate")
+
+// Returns audience info saved in audience bucket au, nil if not saved.
+func audienceInfo(au *bolt.Bucket) (*Audience, error) {
+	data := au.Get(audienceInfoKey)
+	if data == nil {
+		return nil, nil
+	}
+	audience := &Audience{}
+	if err := json.Unmarshal(data, audience); err != nil {
+		return nil, err
+	}
+	return audience, nil
+}
+
+// Check quota of token audience and evict tokens if need.
+// au is bucket of token audience.
+// Relations of audience tokens save issued time unix nano as value,
+// relations saved before quota have empty value and are oldest.
+// Evicted token strings are appended to evicted.
+func (store *BoltDBFileStore) applyQuota(audience *Audience, au *bolt.Bucket, tx *bolt.Tx, evicted *[]string) error {
+	q := audience.quota()
+	if q == nil {
+		return nil
+	}
+
+	if q.MaxTokens > 0 {
+		for {
+			live, oldest, err := liveAudienceTokens(au.Bucket(buckert_oneAudienceTokens), tx)
+			if err != nil {
+				return err
+			}
+			if live < q.MaxTokens {
+				break
+			}
+			if q.Eviction == RejectNew {
+				return ErrQuotaExceeded
+			}
+			if err = store.deleteToken(oldest, tx); err != nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+			*evicted = append(*evicted, oldest)
+		}
+	}
+
+	if q.Rate > 0 {
+		cfg := q.limitConfig()
+		now := time.Now()
+		st := cfg.newState(now)
+		if data := au.Get(issueRateKey); data != nil {
+			if err := json.Unmarshal(data, st); err != nil {
+				return err
+			}
+		}
+		if cfg.allow(st, now) > 0 {
+			return ErrIssueRateExceeded
+		}
+		data, err := json.Marshal(st)
+		if err != nil {
+			return err
+		}
+		return au.Put(issueRateKey, data)
+	}
+	return nil
+}
+
+// Returns count of unexpired tokens and oldest token of audience.
+// Expired tokens are deleted.
+func liveAudienceTokens(bk *bolt.Bucket, tx *bolt.Tx) (live int, oldest string, err error) {
+	var expired []string
+	var oldestAt uint64
+	err = bk.ForEach(func(k, v []byte) error {
+		token, err := getToken(string(k), tx)
+		if err != nil {
+			return err
+		}
+		if token == nil || token.Expired() {
+			expired = append(expired, string(k))
+			return nil
+		}
+		issuedAt := uint64(0)
+		if len(v) == 8 {
+			issuedAt = binary.BigEndian.Uint64(v)
+		}
+		if live == 0 || issuedAt < oldestAt {
+			oldest, oldestAt = string(k), issuedAt
+		}
+		live++
+		return nil
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	for _, k := range expired {
+		if err = deleteAudienceToken(bk, k, tx); err != nil {
+			return 0, "", err
+		}
+	}
+	return live, oldest, nil
+}
+
+// Delete token of audience, relation is deleted even if token not found.
+func deleteAudienceToken(bk *bolt.Bucket, tokenString string, tx *bolt.Tx) error {
+	token, err := getToken(tokenString, tx)
+	if err != nil {
+		return err
+	}
+	if token != nil {
+		if err = deleteDeadlineIndex(token, tx); err != nil {
+			return err
+		}
+		if token.IsSingle() {
+			if err = removeSession(token, tx); err != nil {
+				return err
+			}
+		}
+		if err = tx.Bucket(buckert_alltokens).Delete([]byte(tokenString)); err != nil {
+			return err
+		}
+	}
+	return bk.Delete([]byte(tokenString))
+}
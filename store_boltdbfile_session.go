@@ -0,0 +1,328 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/boltdb/bolt"
+	"time"
+)
+
+// Live tokens of every (audience, SingleID) save in a child bucket of this bucket,
+// child bucket name is singleKey of token.
+// Key is big-endian issued time unix nano + token string, so keys are
+// ordered by issue time. Value is big-endian last used time unix nano.
+var buckert_singlesessions = []byte("bk_single_sessions")
+
+// Returns sessions bucket name of SingleID in audience clientID.
+// Name is SingleID for single token without audience, saved before tokens kept ClientID.
+func singleKey(clientID, singleID string) []byte {
+	if len(clientID) == 0 {
+		return []byte(singleID)
+	}
+	return []byte(clientID + "\x00" + singleID)
+}
+
+func sessionKey(issuedAt int64, tokenString string) []byte {
+	key := make([]byte, 8+len(tokenString))
+	binary.BigEndian.PutUint64(key, uint64(issuedAt))
+	copy(key[8:], tokenString)
+	return key
+}
+
+func sessionValue(usedAt int64) []byte {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(usedAt))
+	return v
+}
+
+// Returns key of token in sessions bucket, nil if not found.
+func findSession(bk *bolt.Bucket, tokenString string) []byte {
+	c := bk.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		if len(k) >= 8 && string(k[8:]) == tokenString {
+			return k
+		}
+	}
+	return nil
+}
+
+// Add token into sessions of its audience and SingleID.
+// Evict or reject by store SingleEviction if SingleID has MaxSingleSessions tokens.
+// Evict oldest if SingleSession policy of audience is set, audience may be nil.
+// Evicted token strings are appended to evicted.
+func (store *BoltDBFileStore) addSession(token *Token, audience *Audience, tx *bolt.Tx, evicted *[]string) error {
+	root, err := tx.CreateBucketIfNotExists(buckert_singlesessions)
+	if err != nil {
+		return err
+	}
+
+	max, eviction := store.MaxSingleSessions, store.SingleEviction
+	if max <= 0 {
+		max = 1
+	}
+	if audience.policy().SingleSession {
+		max, eviction = 1, EvictOldest
+	}
+	for {
+		bk, err := root.CreateBucketIfNotExists(singleKey(token.ClientID, token.SingleID))
+		if err != nil {
+			return err
+		}
+		if countKeys(bk) < max {
+			now := time.Now().UnixNano()
+			return bk.Put(sessionKey(now, token.Value), sessionValue(now))
+		}
+		if eviction == RejectNew {
+			return ErrTooManySessions
+		}
+
+		// Evict one token, bucket may be dropped when empty.
+		victim := append([]byte(nil), sessionVictim(bk, eviction)...)
+		if err = bk.Delete(victim); err != nil {
+			return err
+		}
+		if err = store.deleteToken(string(victim[8:]), tx); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		*evicted = append(*evicted, string(victim[8:]))
+	}
+}
+
+func countKeys(bk *bolt.Bucket) int {
+	n := 0
+	c := bk.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		n++
+	}
+	return n
+}
+
+// Returns key of token to evict by eviction policy.
+func sessionVictim(bk *bolt.Bucket, eviction EvictionPolicy) []byte {
+	c := bk.Cursor()
+	first, _ := c.First()
+	if first == nil || eviction != EvictLeastRecentlyUsed {
+		return first
+	}
+	var victim []byte
+	var oldest uint64
+	for k, v := first, bk.Get(first); k != nil; k, v = c.Next() {
+		used := uint64(0)
+		if len(v) == 8 {
+			used = binary.BigEndian.Uint64(v)
+		}
+		if victim == nil || used < oldest {
+			victim, oldest = k, used
+		}
+	}
+	return victim
+}
+
+// Remove token from sessions of its audience and SingleID.
+func removeSession(token *Token, tx *bolt.Tx) error {
+	root := tx.Bucket(buckert_singlesessions)
+	if root == nil {
+		return nil
+	}
+	name := singleKey(token.ClientID, token.SingleID)
+	bk := root.Bucket(name)
+	if bk == nil {
+		return nil
+	}
+	if key := findSession(bk, token.Value); key != nil {
+		if err := bk.Delete(key); err != nil {
+			return err
+		}
+	}
+	// Drop empty bucket.
+	if k, _ := bk.Cursor().First(); k == nil {
+		return root.DeleteBucket(name)
+	}
+	return nil
+}
+
+// Returns tokens strings of SingleID in audience clientID order by issue time.
+// Empty clientID returns single tokens without audience.
+func (store *BoltDBFileStore) SingleTokens(clientID, singleID string) (tokens []string, err error) {
+	if store.db == nil {
+		return nil, nil
+	}
+	err = store.view(func(tx *bolt.Tx) error {
+		root := tx.Bucket(buckert_singlesessions)
+		if root == nil {
+			return nil
+		}
+		bk := root.Bucket(singleKey(clientID, singleID))
+		if bk == nil {
+			return nil
+		}
+		return bk.ForEach(func(k, v []byte) error {
+			tokens = append(tokens, string(k[8:]))
+			return nil
+		})
+	})
+	return
+}
+
+// Record token used at usedAt, time unix nano.
+// Update LastUsed of token session and last used time of single token.
+// Skip the write if token was touched within TouchInterval.
+func (store *BoltDBFileStore) TouchToken(tokenString string, usedAt int64) error {
+	if store.db == nil || len(tokenString) == 0 {
+		return nil
+	}
+	interval := int64(store.TouchInterval)
+
+	var token *Token
+	needWrite := false
+	err := store.view(func(tx *bolt.Tx) error {
+		var err error
+		if token, err = getToken(tokenString, tx); err != nil || token == nil {
+			return err
+		}
+		if token.Session != nil && usedAt-token.Session.LastUsed*int64(time.Second) >= interval {
+			needWrite = true
+		}
+		if key, v := sessionOf(token, tx); key != nil && len(v) == 8 {
+			if usedAt-int64(binary.BigEndian.Uint64(v)) >= interval {
+				needWrite = true
+			}
+		}
+		return nil
+	})
+	if err != nil || !needWrite {
+		return err
+	}
+
+	return store.update(func(tx *bolt.Tx) error {
+		token, err := getToken(tokenString, tx)
+		if err != nil || token == nil {
+			return err
+		}
+		if token.Session != nil {
+			token.Session.LastUsed = usedAt / int64(time.Second)
+			data, err := json.Marshal(token)
+			if err != nil {
+				return err
+			}
+			if err = tx.Bucket(buckert_alltokens).Put([]byte(token.Value), data); err != nil {
+				return err
+			}
+		}
+		if key, _ := sessionOf(token, tx); key != nil {
+			return tx.Bucket(buckert_singlesessions).Bucket(singleKey(token.ClientID, token.SingleID)).Put(key, sessionValue(usedAt))
+		}
+		return nil
+	})
+}
+
+// Returns live tokens of SingleID in audience clientID order by issue time.
+func (store *BoltDBFileStore) TokensOfSingle(clientID, singleID string) ([]*Token, error) {
+	values, err := store.SingleTokens(clientID, singleID)
+	if err != nil {
+		return nil, err
+	}
+	return store.getTokens(values)
+}
+
+// Returns live tokens of audience.
+func (store *BoltDBFileStore) TokensOfAudience(clientID string) ([]*Token, error) {
+	if store.db == nil {
+		return nil, nil
+	}
+	var values []string
+	err := store.view(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte(clientID))
+		if bk == nil {
+			return nil
+		}
+		return bk.Bucket(buckert_oneAudienceTokens).ForEach(func(k, v []byte) error {
+			values = append(values, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store.getTokens(values)
+}
+
+// Returns unexpired tokens of values.
+func (store *BoltDBFileStore) getTokens(values []string) ([]*Token, error) {
+	tokens := make([]*Token, 0, len(values))
+	err := store.view(func(tx *bolt.Tx) error {
+		for _, v := range values {
+			token, err := getToken(v, tx)
+			if err != nil {
+				return err
+			}
+			if token != nil && !token.Expired() {
+				tokens = append(tokens, token)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Returns session key and value of single token.
+func sessionOf(token *Token, tx *bolt.Tx) ([]byte, []byte) {
+	root := tx.Bucket(buckert_singlesessions)
+	if root == nil {
+		return nil, nil
+	}
+	bk := root.Bucket(singleKey(token.ClientID, token.SingleID))
+	if bk == nil {
+		return nil, nil
+	}
+	key := findSession(bk, token.Value)
+	if key == nil {
+		return nil, nil
+	}
+	return key, bk.Get(key)
+}
+
+// Move single token relations of bk_token_singleIDs into sessions bucket,
+// scoped by ClientID of saved token.
+// Does nothing if db has no bk_token_singleIDs bucket.
+func migrateSingleIDs(tx *bolt.Tx) error {
+	old := tx.Bucket(buckert_singletokens_singledids)
+	if old == nil {
+		return nil
+	}
+	root, err := tx.CreateBucketIfNotExists(buckert_singlesessions)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixNano()
+	err = old.ForEach(func(k, v []byte) error {
+		token, err := getToken(string(v), tx)
+		if err != nil {
+			return err
+		}
+		clientID := ""
+		if token != nil {
+			clientID = token.ClientID
+		}
+		bk, err := root.CreateBucketIfNotExists(singleKey(clientID, string(k)))
+		if err != nil {
+			return err
+		}
+		if findSession(bk, string(v)) != nil {
+			return nil
+		}
+		return bk.Put(sessionKey(now, string(v)), sessionValue(now))
+	})
+	if err != nil {
+		return err
+	}
+	return tx.DeleteBucket(buckert_singletokens_singledids)
+}
@@ -5,9 +5,13 @@
 package tokenauth_test
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/boltdb/bolt"
 	"github.com/ysqi/tokenauth"
 	. "gopkg.in/check.v1"
+	"net/http"
 	"sync"
 	"time"
 )
@@ -213,10 +217,11 @@ func (s *S) TestStore_Bolt_Token_Save_Empty(c *C) {
 	err = st.SaveToken(&tokenauth.Token{ClientID: "id", Value: "value"})
 	c.Assert(err, NotNil)
 
+	// Single token of audience not stored.
 	err = st.SaveToken(&tokenauth.Token{ClientID: "id", SingleID: "SingleID", Value: "value"})
-	c.Assert(err, NotNil)
+	c.Assert(err, IsNil)
 
-	err = st.SaveToken(&tokenauth.Token{SingleID: "SingleID", Value: "value"})
+	err = st.SaveToken(&tokenauth.Token{SingleID: "SingleID", Value: "value2"})
 	c.Assert(err, IsNil)
 
 }
@@ -248,6 +253,7 @@ func (s *S) TestStore_Bolt_SinglnToken_Save(c *C) {
 	st := openBoltStore()
 	defer st.Close()
 
+	// Audience is not saved in st.
 	item := newAudience()
 	var err error
 	for ii := 0; ii < 10; ii++ {
@@ -257,7 +263,8 @@ func (s *S) TestStore_Bolt_SinglnToken_Save(c *C) {
 			tokens[i], err = tokenauth.NewSingleToken(fmt.Sprintf("singleID%d", ii), item, keyPorvider.GenerateTokenString)
 			c.Assert(err, IsNil)
 			c.Assert(tokens[i], NotNil)
-			st.SaveToken(tokens[i])
+			c.Assert(tokens[i].ClientID, Equals, item.ID)
+			c.Assert(st.SaveToken(tokens[i]), IsNil)
 		}
 		for i := 0; i < 10; i++ {
 			newToken, err := st.GetToken(tokens[i].Value)
@@ -291,8 +298,13 @@ func (s *S) TestStore_Bolt_Token_Save_Effective(c *C) {
 
 	token.DeadLine = time.Now().Unix() + 1
 	err = st.SaveToken(token)
-	c.Assert(err, IsNil)
+	c.Assert(errors.Is(err, tokenauth.ErrTokenExists), Equals, true)
+	c.Assert(tokenauth.HTTPStatus(err), Equals, http.StatusConflict)
 
+	// Existing token is not changed.
+	saved, err := st.GetToken(token.Value)
+	c.Assert(err, IsNil)
+	c.Assert(saved.DeadLine, Equals, int64(0))
 }
 
 func (s *S) TestStore_Bolt_Token_Get_Empty(c *C) {
@@ -337,6 +349,27 @@ func (s *S) TestStore_Bolt_Token_Effective(c *C) {
 	c.Assert(newToken.Expired(), Equals, true)
 }
 
+func (s *S) TestStore_Bolt_Token_Count(c *C) {
+
+	st := openBoltStore()
+	defer st.Close()
+
+	count, err := st.CountTokens()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 0)
+
+	item := newAudience()
+	st.SaveAudience(item)
+	for i := 0; i < 5; i++ {
+		token, _ := tokenauth.NewToken(item, keyPorvider.GenerateTokenString)
+		st.SaveToken(token)
+	}
+
+	count, err = st.CountTokens()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 5)
+}
+
 func (s *S) TestStore_Bolt_Token_Delete(c *C) {
 
 	st := openBoltStore()
@@ -364,6 +397,55 @@ func (s *S) TestStore_Bolt_Token_Delete(c *C) {
 	c.Assert(newToken, IsNil)
 }
 
+func (s *S) TestStore_Bolt_Token_DeleteExpired(c *C) {
+
+	st := openBoltStore()
+	defer st.Close()
+	st.ExpireBatchSize = 3
+
+	count, err := st.DeleteExpired()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 0)
+
+	item := newAudience()
+	st.SaveAudience(item)
+
+	tokens := make([]*tokenauth.Token, 10)
+	for i := 0; i < 10; i++ {
+		tokens[i], _ = tokenauth.NewToken(item, keyPorvider.GenerateTokenString)
+		switch i % 3 {
+		case 0:
+			tokens[i].DeadLine = time.Now().Unix() + 1
+		case 1:
+			tokens[i].DeadLine = time.Now().Unix() + 100
+		default:
+			tokens[i].DeadLine = 0
+		}
+		err = st.SaveToken(tokens[i])
+		c.Assert(err, IsNil)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	count, err = st.DeleteExpired()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 4)
+
+	for i := 0; i < 10; i++ {
+		newToken, err := st.GetToken(tokens[i].Value)
+		c.Assert(err, IsNil)
+		if i%3 == 0 {
+			c.Assert(newToken, IsNil)
+		} else {
+			c.Assert(newToken, DeepEquals, tokens[i])
+		}
+	}
+
+	count, err = st.DeleteExpired()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 0)
+}
+
 func (s *S) T2estStore_Bolt_Token_Get_Complicating(c *C) {
 	st := openBoltStore()
 	defer st.Close()
@@ -419,3 +501,157 @@ func newAudience() *tokenauth.Audience {
 	item, _ := tokenauth.NewAudience("test", keyPorvider.GenerateSecretString)
 	return item
 }
+
+func newSingleStore(max int, policy tokenauth.EvictionPolicy) *tokenauth.BoltDBFileStore {
+	st := openBoltStore()
+	st.MaxSingleSessions = max
+	st.SingleEviction = policy
+	st.TouchInterval = 0
+	return st
+}
+
+func (s *S) TestStore_Bolt_SingleSessions_Oldest(c *C) {
+	st := newSingleStore(3, tokenauth.EvictOldest)
+	defer st.Close()
+
+	tokens := make([]string, 5)
+	for i := 0; i < 5; i++ {
+		tokens[i] = fmt.Sprintf("oldest%d", i)
+		err := st.SaveToken(&tokenauth.Token{SingleID: "user", Value: tokens[i]})
+		c.Assert(err, IsNil)
+	}
+
+	live, err := st.SingleTokens("", "user")
+	c.Assert(err, IsNil)
+	c.Assert(live, DeepEquals, tokens[2:])
+
+	for i := 0; i < 2; i++ {
+		token, _ := st.GetToken(tokens[i])
+		c.Assert(token, IsNil)
+	}
+}
+
+func (s *S) TestStore_Bolt_SingleSessions_LRU(c *C) {
+	st := newSingleStore(2, tokenauth.EvictLeastRecentlyUsed)
+	defer st.Close()
+
+	st.SaveToken(&tokenauth.Token{SingleID: "user", Value: "phone"})
+	st.SaveToken(&tokenauth.Token{SingleID: "user", Value: "laptop"})
+	err := st.TouchToken("phone", time.Now().Add(time.Minute).UnixNano())
+	c.Assert(err, IsNil)
+
+	st.SaveToken(&tokenauth.Token{SingleID: "user", Value: "tablet"})
+
+	live, _ := st.SingleTokens("", "user")
+	c.Assert(live, DeepEquals, []string{"phone", "tablet"})
+}
+
+func (s *S) TestStore_Bolt_SingleSessions_Reject(c *C) {
+	st := newSingleStore(2, tokenauth.RejectNew)
+	defer st.Close()
+
+	c.Assert(st.SaveToken(&tokenauth.Token{SingleID: "user", Value: "phone"}), IsNil)
+	c.Assert(st.SaveToken(&tokenauth.Token{SingleID: "user", Value: "laptop"}), IsNil)
+	c.Assert(st.SaveToken(&tokenauth.Token{SingleID: "user", Value: "tablet"}), Equals, tokenauth.ErrTooManySessions)
+	// Save again is rejected.
+	err := st.SaveToken(&tokenauth.Token{SingleID: "user", Value: "phone"})
+	c.Assert(errors.Is(err, tokenauth.ErrTokenExists), Equals, true)
+
+	// Free one session.
+	c.Assert(st.DeleteToken("phone"), IsNil)
+	c.Assert(st.SaveToken(&tokenauth.Token{SingleID: "user", Value: "tablet"}), IsNil)
+
+	live, _ := st.SingleTokens("", "user")
+	c.Assert(live, DeepEquals, []string{"laptop", "tablet"})
+}
+
+func (s *S) TestStore_Bolt_SingleSessions_Migrate(c *C) {
+	file := tempfile()
+	db, err := bolt.Open(file, 0666, nil)
+	c.Assert(err, IsNil)
+	token := &tokenauth.Token{SingleID: "user", Value: "oldtoken"}
+	data, _ := json.Marshal(token)
+	db.Update(func(tx *bolt.Tx) error {
+		bk, _ := tx.CreateBucketIfNotExists([]byte("bk_all_tokeninfo"))
+		bk.Put([]byte(token.Value), data)
+		ids, _ := tx.CreateBucketIfNotExists([]byte("bk_token_singleIDs"))
+		return ids.Put([]byte(token.SingleID), []byte(token.Value))
+	})
+	db.Close()
+
+	st := tokenauth.NewBoltDBFileStore()
+	c.Assert(st.Open(fmt.Sprintf(`{"path":"%s"}`, file)), IsNil)
+	defer st.Close()
+
+	live, err := st.SingleTokens("", "user")
+	c.Assert(err, IsNil)
+	c.Assert(live, DeepEquals, []string{"oldtoken"})
+
+	// Old token is replaced by new one as before.
+	st.SaveToken(&tokenauth.Token{SingleID: "user", Value: "newtoken"})
+	old, _ := st.GetToken("oldtoken")
+	c.Assert(old, IsNil)
+}
+
+func (s *S) TestStore_Bolt_SingleSessions_Audience(c *C) {
+	st := newSingleStore(1, tokenauth.EvictOldest)
+	defer st.Close()
+
+	a1, a2 := newAudience(), newAudience()
+	st.SaveAudience(a1)
+	st.SaveAudience(a2)
+
+	// Same SingleID in other audience is other session.
+	c.Assert(st.SaveToken(&tokenauth.Token{ClientID: a1.ID, SingleID: "user", Value: "a1token"}), IsNil)
+	c.Assert(st.SaveToken(&tokenauth.Token{ClientID: a2.ID, SingleID: "user", Value: "a2token"}), IsNil)
+	live, _ := st.SingleTokens(a1.ID, "user")
+	c.Assert(live, DeepEquals, []string{"a1token"})
+	live, _ = st.SingleTokens(a2.ID, "user")
+	c.Assert(live, DeepEquals, []string{"a2token"})
+
+	tokens, err := st.TokensOfAudience(a1.ID)
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 1)
+	c.Assert(tokens[0].SingleID, Equals, "user")
+
+	// Replaced in the same audience.
+	c.Assert(st.SaveToken(&tokenauth.Token{ClientID: a1.ID, SingleID: "user", Value: "a1new"}), IsNil)
+	live, _ = st.SingleTokens(a1.ID, "user")
+	c.Assert(live, DeepEquals, []string{"a1new"})
+	tokens, _ = st.TokensOfAudience(a1.ID)
+	c.Assert(len(tokens), Equals, 1)
+
+	// Deleted with audience.
+	c.Assert(st.DeleteAudience(a1.ID), IsNil)
+	token, _ := st.GetToken("a1new")
+	c.Assert(token, IsNil)
+	live, _ = st.SingleTokens(a1.ID, "user")
+	c.Assert(live, IsNil)
+	live, _ = st.SingleTokens(a2.ID, "user")
+	c.Assert(live, DeepEquals, []string{"a2token"})
+}
+
+func (s *S) TestStore_Bolt_SingleSessions_MigrateAudience(c *C) {
+	file := tempfile()
+	db, err := bolt.Open(file, 0666, nil)
+	c.Assert(err, IsNil)
+	token := &tokenauth.Token{ClientID: "client", SingleID: "user", Value: "oldtoken"}
+	data, _ := json.Marshal(token)
+	db.Update(func(tx *bolt.Tx) error {
+		bk, _ := tx.CreateBucketIfNotExists([]byte("bk_all_tokeninfo"))
+		bk.Put([]byte(token.Value), data)
+		ids, _ := tx.CreateBucketIfNotExists([]byte("bk_token_singleIDs"))
+		return ids.Put([]byte(token.SingleID), []byte(token.Value))
+	})
+	db.Close()
+
+	st := tokenauth.NewBoltDBFileStore()
+	c.Assert(st.Open(fmt.Sprintf(`{"path":"%s"}`, file)), IsNil)
+	defer st.Close()
+
+	live, err := st.SingleTokens("client", "user")
+	c.Assert(err, IsNil)
+	c.Assert(live, DeepEquals, []string{"oldtoken"})
+	live, _ = st.SingleTokens("", "user")
+	c.Assert(live, IsNil)
+}
@@ -0,0 +1,563 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// default max count of cached tokens and audiences.
+	DefaultCacheEntries = 10000
+	// default time to live of cached token and audience.
+	DefaultCacheTTL = time.Minute
+	// default time to live of unknown token and audience.
+	DefaultCacheNegativeTTL = 5 * time.Second
+)
+
+// CacheStore wraps any TokenStore and keeps a bounded LRU cache of
+// tokens and audiences in memory.
+// Token is cached until token DeadLine at most, unknown token and audience
+// are also cached for NegativeTTL.
+// Cache is invalidated when token or audience changed by this store,
+// or by other stores when use the same InvalidationBus.
+type CacheStore struct {
+	Alias string
+	store TokenStore
+
+	MaxEntries  int           // max count of cached entries, 0 is DefaultCacheEntries.
+	TTL         time.Duration // time to live of entry, 0 is DefaultCacheTTL.
+	NegativeTTL time.Duration // time to live of unknown entry, 0 is DefaultCacheNegativeTTL.
+
+	// Called when publish to bus fails, nil writes log.
+	// Change of wrapped store is done, so the error is not returned.
+	OnPublishError func(event *InvalidationEvent, err error)
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	gen   uint64                // changed by invalidations of many entries.
+	loads map[string]*cacheLoad // reads of wrapped store in progress by key.
+
+	bus         InvalidationBus
+	origin      string
+	unsubscribe func()
+}
+
+// cache entry, token and audience are nil if not found in store.
+type cacheEntry struct {
+	key      string
+	token    *Token
+	audience *Audience
+	expires  time.Time
+}
+
+// Read of wrapped store in progress.
+// Result is not cached if its key is invalidated during read.
+type cacheLoad struct {
+	count int
+	stale bool
+}
+
+// New cache store in front of store.
+func NewCacheStore(store TokenStore, maxEntries int, ttl time.Duration) *CacheStore {
+	if store == nil {
+		panic("tokenauth: cache store is nil")
+	}
+	return &CacheStore{
+		Alias:      "CacheStore",
+		store:      store,
+		MaxEntries: maxEntries,
+		TTL:        ttl,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// Returns the wrapped store.
+func (c *CacheStore) Store() TokenStore {
+	return c.store
+}
+
+// Token is cached by fingerprint, same as invalidation events.
+func tokenCacheKey(tokenString string) string {
+	return fingerprintCacheKey(TokenFingerprint(tokenString))
+}
+
+func fingerprintCacheKey(fingerprint string) string {
+	return "t:" + fingerprint
+}
+
+func audienceCacheKey(clientID string) string {
+	return "a:" + clientID
+}
+
+// Use bus to publish invalidation events and subscribe events of other stores.
+// Stop subscription of old bus if exist.
+func (c *CacheStore) UseBus(bus InvalidationBus) error {
+	if c.unsubscribe != nil {
+		c.unsubscribe()
+		c.unsubscribe = nil
+	}
+	c.bus = nil
+	if bus == nil {
+		return nil
+	}
+	origin := NewObjectId().Hex()
+	cancel, err := bus.Subscribe(func(event *InvalidationEvent) {
+		if event.Origin != origin {
+			c.applyEvent(event)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	c.bus, c.origin, c.unsubscribe = bus, origin, cancel
+	return nil
+}
+
+// Apply invalidation event to cache.
+func (c *CacheStore) applyEvent(event *InvalidationEvent) {
+	if event.Kind == InvalidatePurge {
+		c.Purge()
+		return
+	}
+	if len(event.Fingerprint) > 0 {
+		c.mu.Lock()
+		c.remove(fingerprintCacheKey(event.Fingerprint))
+		c.mu.Unlock()
+	}
+	if len(event.ClientID) > 0 {
+		c.InvalidateAudience(event.ClientID)
+	}
+	if len(event.SingleID) > 0 {
+		c.invalidateSingle(event.SingleClientID, event.SingleID)
+	}
+}
+
+// Publish invalidation event if use bus, error is passed to OnPublishError.
+func (c *CacheStore) publish(event *InvalidationEvent) {
+	if c.bus == nil {
+		return
+	}
+	event.Origin = c.origin
+	if err := c.bus.Publish(event); err != nil {
+		if c.OnPublishError != nil {
+			c.OnPublishError(event, err)
+		} else {
+			log.Printf("tokenauth: publish %s invalidation fail: %v", event.Kind, err)
+		}
+	}
+}
+
+// Init wrapped store.
+func (c *CacheStore) Open(config string) error {
+	c.Purge()
+	return c.store.Open(config)
+}
+
+// Close wrapped store and clear cache.
+// Stop subscription of bus, but not close bus.
+func (c *CacheStore) Close() error {
+	c.UseBus(nil)
+	c.Purge()
+	return c.store.Close()
+}
+
+// Save audience into wrapped store.
+// Clear cached audience and its tokens.
+func (c *CacheStore) SaveAudience(audience *Audience) error {
+	err := c.store.SaveAudience(audience)
+	if audience != nil {
+		c.InvalidateAudience(audience.ID)
+	}
+	if err != nil {
+		return err
+	}
+	c.publish(&InvalidationEvent{Kind: InvalidateRotate, ClientID: audience.ID})
+	return nil
+}
+
+// Update audience in wrapped store and clear cached audience.
+// Returns error if wrapped store is not an AudienceUpdater.
+func (c *CacheStore) UpdateAudience(audience *Audience) error {
+	updater, ok := c.store.(AudienceUpdater)
+	if !ok {
+		return fmt.Errorf("tokenauth: wrapped store can not update audience: %w", ErrNotSupported)
+	}
+	err := updater.UpdateAudience(audience)
+	if audience != nil {
+		c.InvalidateAudience(audience.ID)
+	}
+	if err != nil {
+		return err
+	}
+	c.publish(&InvalidationEvent{Kind: InvalidateRotate, ClientID: audience.ID})
+	return nil
+}
+
+// Delete audience from wrapped store.
+// Clear cached audience and its tokens.
+func (c *CacheStore) DeleteAudience(clientID string) error {
+	err := c.store.DeleteAudience(clientID)
+	c.InvalidateAudience(clientID)
+	if err != nil {
+		return err
+	}
+	c.publish(&InvalidationEvent{Kind: InvalidateDelete, ClientID: clientID})
+	return nil
+}
+
+// Get audience from cache or wrapped store.
+func (c *CacheStore) GetAudience(clientID string) (*Audience, error) {
+	key := audienceCacheKey(clientID)
+	if e, ok := c.get(key); ok {
+		return copyAudience(e.audience), nil
+	}
+
+	gen := c.beginLoad(key)
+	audience, err := c.store.GetAudience(clientID)
+	if err != nil {
+		c.mu.Lock()
+		c.endLoad(key)
+		c.mu.Unlock()
+		return nil, err
+	}
+	c.add(&cacheEntry{key: key, audience: copyAudience(audience)}, 0, gen)
+	return audience, nil
+}
+
+// Save token into wrapped store.
+// Clear cached token, and old token of same SingleID if token is single.
+// Tokens evicted by wrapped store are revoked if it is a TokenEvicter.
+// Returns ErrTokenExists of wrapped store.
+func (c *CacheStore) SaveToken(token *Token) error {
+	_, err := c.SaveTokenEvicted(token)
+	return err
+}
+
+// Same as SaveToken, also returns token strings evicted by wrapped store.
+// Returns nil evicted if wrapped store is not a TokenEvicter.
+func (c *CacheStore) SaveTokenEvicted(token *Token) (evicted []string, err error) {
+	if evicter, ok := c.store.(TokenEvicter); ok {
+		evicted, err = evicter.SaveTokenEvicted(token)
+	} else {
+		err = c.store.SaveToken(token)
+	}
+	if token == nil {
+		return evicted, err
+	}
+	// Existing token is not changed, only drop cached unknown token.
+	if errors.Is(err, ErrTokenExists) {
+		c.InvalidateToken(token.Value)
+		return nil, err
+	}
+	event := &InvalidationEvent{Kind: InvalidateRotate, Fingerprint: TokenFingerprint(token.Value)}
+	if token.IsSingle() {
+		event.SingleID, event.SingleClientID = token.SingleID, token.ClientID
+	}
+	c.applyEvent(event)
+	if err != nil {
+		return nil, err
+	}
+	for _, tokenString := range evicted {
+		c.InvalidateToken(tokenString)
+	}
+	c.publish(event)
+	for _, tokenString := range evicted {
+		c.publish(&InvalidationEvent{Kind: InvalidateRevoke, Fingerprint: TokenFingerprint(tokenString)})
+	}
+	return evicted, nil
+}
+
+// Delete token from wrapped store and cache.
+func (c *CacheStore) DeleteToken(tokenString string) error {
+	err := c.store.DeleteToken(tokenString)
+	c.InvalidateToken(tokenString)
+	if err != nil {
+		return err
+	}
+	c.publish(&InvalidationEvent{Kind: InvalidateRevoke, Fingerprint: TokenFingerprint(tokenString)})
+	return nil
+}
+
+// Get token from cache or wrapped store.
+func (c *CacheStore) GetToken(tokenString string) (*Token, error) {
+	key := tokenCacheKey(tokenString)
+	if e, ok := c.get(key); ok {
+		return copyToken(e.token), nil
+	}
+
+	gen := c.beginLoad(key)
+	token, err := c.store.GetToken(tokenString)
+	if err != nil {
+		c.mu.Lock()
+		c.endLoad(key)
+		c.mu.Unlock()
+		return nil, err
+	}
+	var deadLine int64
+	if token != nil {
+		deadLine = token.DeadLine
+	}
+	c.add(&cacheEntry{key: key, token: copyToken(token)}, deadLine, gen)
+	return token, nil
+}
+
+// Delete expired tokens from wrapped store.
+// Cached tokens expire at their DeadLine by themselves.
+func (c *CacheStore) DeleteExpired() (int, error) {
+	return c.store.DeleteExpired()
+}
+
+// Extend token in wrapped store and clear cached token.
+// Returns error if wrapped store is not a TokenExtender.
+func (c *CacheStore) ExtendToken(tokenString string, deadLine int64) error {
+	extender, ok := c.store.(TokenExtender)
+	if !ok {
+		return fmt.Errorf("tokenauth: wrapped store can not extend token: %w", ErrNotSupported)
+	}
+	err := extender.ExtendToken(tokenString, deadLine)
+	c.InvalidateToken(tokenString)
+	if err != nil {
+		return err
+	}
+	c.publish(&InvalidationEvent{Kind: InvalidateRotate, Fingerprint: TokenFingerprint(tokenString)})
+	return nil
+}
+
+// Purge soft deleted audiences in wrapped store if it is an AudiencePurger.
+// Cache is cleared if any audience purged.
+func (c *CacheStore) PurgeAudiences(deletedBefore int64) (int, error) {
+	purger, ok := c.store.(AudiencePurger)
+	if !ok {
+		return 0, nil
+	}
+	count, err := purger.PurgeAudiences(deletedBefore)
+	if count > 0 {
+		c.Purge()
+	}
+	return count, err
+}
+
+// Returns count of tokens in wrapped store.
+// Returns error if wrapped store is not a TokenCounter.
+func (c *CacheStore) CountTokens() (int, error) {
+	if counter, ok := c.store.(TokenCounter); ok {
+		return counter.CountTokens()
+	}
+	return 0, fmt.Errorf("tokenauth: wrapped store can not count tokens: %w", ErrNotSupported)
+}
+
+// Record token used in wrapped store if it is a TokenToucher.
+func (c *CacheStore) TouchToken(tokenString string, usedAt int64) error {
+	if toucher, ok := c.store.(TokenToucher); ok {
+		return toucher.TouchToken(tokenString, usedAt)
+	}
+	return nil
+}
+
+// Returns live tokens of SingleID from wrapped store.
+// Returns error if wrapped store is not a TokenLister.
+func (c *CacheStore) TokensOfSingle(clientID, singleID string) ([]*Token, error) {
+	if lister, ok := c.store.(TokenLister); ok {
+		return lister.TokensOfSingle(clientID, singleID)
+	}
+	return nil, fmt.Errorf("tokenauth: wrapped store can not list tokens: %w", ErrNotSupported)
+}
+
+// Returns live tokens of audience from wrapped store.
+// Returns error if wrapped store is not a TokenLister.
+func (c *CacheStore) TokensOfAudience(clientID string) ([]*Token, error) {
+	if lister, ok := c.store.(TokenLister); ok {
+		return lister.TokensOfAudience(clientID)
+	}
+	return nil, fmt.Errorf("tokenauth: wrapped store can not list tokens: %w", ErrNotSupported)
+}
+
+// Remove token from cache.
+func (c *CacheStore) InvalidateToken(tokenString string) {
+	c.mu.Lock()
+	c.remove(tokenCacheKey(tokenString))
+	c.mu.Unlock()
+}
+
+// Remove audience and all tokens of audience from cache.
+func (c *CacheStore) InvalidateAudience(clientID string) {
+	c.mu.Lock()
+	c.remove(audienceCacheKey(clientID))
+	c.mu.Unlock()
+	c.removeTokens(func(t *Token) bool {
+		return t.ClientID == clientID
+	})
+}
+
+// Remove cached single tokens of singleID in audience clientID.
+func (c *CacheStore) invalidateSingle(clientID, singleID string) {
+	c.removeTokens(func(t *Token) bool {
+		return t.IsSingle() && t.ClientID == clientID && t.SingleID == singleID
+	})
+}
+
+// Clear cache.
+func (c *CacheStore) Purge() {
+	c.mu.Lock()
+	c.ll = list.New()
+	c.items = make(map[string]*list.Element)
+	c.gen++
+	c.mu.Unlock()
+}
+
+// Returns count of cached entries.
+func (c *CacheStore) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Returns unexpired entry of key.
+func (c *CacheStore) get(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if !time.Now().Before(e.expires) {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return e, true
+}
+
+// Start read of key from wrapped store.
+// Returns generation passed to add.
+func (c *CacheStore) beginLoad(key string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loads == nil {
+		c.loads = make(map[string]*cacheLoad)
+	}
+	l := c.loads[key]
+	if l == nil {
+		l = &cacheLoad{}
+		c.loads[key] = l
+	}
+	l.count++
+	return c.gen
+}
+
+// End read of key, must hold lock.
+// Returns true if key is invalidated during read.
+func (c *CacheStore) endLoad(key string) (stale bool) {
+	l := c.loads[key]
+	if l == nil {
+		return false
+	}
+	if l.count--; l.count <= 0 {
+		delete(c.loads, key)
+	}
+	return l.stale
+}
+
+// Add entry read from wrapped store at generation gen,
+// never cache entry after deadLine if deadLine is not 0.
+// Entry is dropped if it is invalidated during read.
+func (c *CacheStore) add(e *cacheEntry, deadLine int64, gen uint64) {
+	now := time.Now()
+	ttl := c.TTL
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	if e.token == nil && e.audience == nil {
+		ttl = c.NegativeTTL
+		if ttl <= 0 {
+			ttl = DefaultCacheNegativeTTL
+		}
+	}
+	e.expires = now.Add(ttl)
+	if deadLine != 0 {
+		if d := time.Unix(deadLine, 0); d.Before(e.expires) {
+			e.expires = d
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if stale := c.endLoad(e.key); stale || gen != c.gen || !now.Before(e.expires) {
+		return
+	}
+	if el, ok := c.items[e.key]; ok {
+		el.Value = e
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[e.key] = c.ll.PushFront(e)
+
+	max := c.MaxEntries
+	if max <= 0 {
+		max = DefaultCacheEntries
+	}
+	for c.ll.Len() > max {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.items, el.Value.(*cacheEntry).key)
+	}
+}
+
+// Remove entry of key, must hold lock.
+// Read of key in progress is not cached.
+func (c *CacheStore) remove(key string) {
+	if el, ok := c.items[key]; ok {
+		c.ll.Remove(el)
+		delete(c.items, key)
+	}
+	if l := c.loads[key]; l != nil {
+		l.stale = true
+	}
+}
+
+// Remove all cached tokens matched.
+// Reads in progress are not cached, matched tokens are unknown before read.
+func (c *CacheStore) removeTokens(match func(t *Token) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+		if e := el.Value.(*cacheEntry); e.token != nil && match(e.token) {
+			c.ll.Remove(el)
+			delete(c.items, e.key)
+		}
+		el = next
+	}
+}
+
+func copyToken(t *Token) *Token {
+	if t == nil {
+		return nil
+	}
+	n := *t
+	return &n
+}
+
+func copyAudience(a *Audience) *Audience {
+	if a == nil {
+		return nil
+	}
+	n := *a
+	return &n
+}
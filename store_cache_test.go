@@ -0,0 +1,241 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ysqi/tokenauth"
+	. "gopkg.in/check.v1"
+	"time"
+)
+
+func (s *S) TestStore_Cache_Token(c *C) {
+
+	bolt := openBoltStore()
+	st := tokenauth.NewCacheStore(bolt, 100, time.Minute)
+	defer st.Close()
+
+	item := newAudience()
+	err := st.SaveAudience(item)
+	c.Assert(err, IsNil)
+
+	token, _ := tokenauth.NewToken(item, keyPorvider.GenerateTokenString)
+	err = st.SaveToken(token)
+	c.Assert(err, IsNil)
+
+	newToken, err := st.GetToken(token.Value)
+	c.Assert(err, IsNil)
+	c.Assert(newToken, DeepEquals, token)
+
+	// Served from cache after deleted from wrapped store.
+	bolt.DeleteToken(token.Value)
+	newToken, err = st.GetToken(token.Value)
+	c.Assert(err, IsNil)
+	c.Assert(newToken, DeepEquals, token)
+
+	// Invalidate on delete.
+	st.DeleteToken(token.Value)
+	newToken, err = st.GetToken(token.Value)
+	c.Assert(err, IsNil)
+	c.Assert(newToken, IsNil)
+}
+
+func (s *S) TestStore_Cache_Negative(c *C) {
+
+	bolt := openBoltStore()
+	st := tokenauth.NewCacheStore(bolt, 100, time.Minute)
+	defer st.Close()
+
+	item := newAudience()
+	st.SaveAudience(item)
+	token, _ := tokenauth.NewToken(item, keyPorvider.GenerateTokenString)
+
+	newToken, err := st.GetToken(token.Value)
+	c.Assert(err, IsNil)
+	c.Assert(newToken, IsNil)
+
+	// Unknown token is cached.
+	bolt.SaveToken(token)
+	newToken, err = st.GetToken(token.Value)
+	c.Assert(err, IsNil)
+	c.Assert(newToken, IsNil)
+
+	// Invalidate on save, token exists in wrapped store.
+	err = st.SaveToken(token)
+	c.Assert(errors.Is(err, tokenauth.ErrTokenExists), Equals, true)
+	newToken, err = st.GetToken(token.Value)
+	c.Assert(err, IsNil)
+	c.Assert(newToken, DeepEquals, token)
+}
+
+func (s *S) TestStore_Cache_DeadLine(c *C) {
+
+	bolt := openBoltStore()
+	st := tokenauth.NewCacheStore(bolt, 100, time.Minute)
+	defer st.Close()
+
+	item := newAudience()
+	st.SaveAudience(item)
+	token, _ := tokenauth.NewToken(item, keyPorvider.GenerateTokenString)
+	token.DeadLine = time.Now().Unix() + 1
+	st.SaveToken(token)
+
+	newToken, _ := st.GetToken(token.Value)
+	c.Assert(newToken, DeepEquals, token)
+
+	// Cache never outlives token.
+	bolt.DeleteToken(token.Value)
+	time.Sleep(2 * time.Second)
+	newToken, err := st.GetToken(token.Value)
+	c.Assert(err, IsNil)
+	c.Assert(newToken, IsNil)
+}
+
+func (s *S) TestStore_Cache_Audience(c *C) {
+
+	bolt := openBoltStore()
+	st := tokenauth.NewCacheStore(bolt, 100, time.Minute)
+	defer st.Close()
+
+	item := newAudience()
+	st.SaveAudience(item)
+
+	tokens := make([]*tokenauth.Token, 5)
+	for i := 0; i < 5; i++ {
+		tokens[i], _ = tokenauth.NewToken(item, keyPorvider.GenerateTokenString)
+		st.SaveToken(tokens[i])
+		st.GetToken(tokens[i].Value)
+	}
+	newItem, err := st.GetAudience(item.ID)
+	c.Assert(err, IsNil)
+	c.Assert(newItem, DeepEquals, item)
+
+	err = st.DeleteAudience(item.ID)
+	c.Assert(err, IsNil)
+
+	newItem, err = st.GetAudience(item.ID)
+	c.Assert(err, IsNil)
+	c.Assert(newItem, IsNil)
+	for i := 0; i < 5; i++ {
+		newToken, err := st.GetToken(tokens[i].Value)
+		c.Assert(err, IsNil)
+		c.Assert(newToken, IsNil)
+	}
+}
+
+func (s *S) TestStore_Cache_LRU(c *C) {
+
+	bolt := openBoltStore()
+	st := tokenauth.NewCacheStore(bolt, 3, time.Minute)
+	defer st.Close()
+
+	for i := 0; i < 10; i++ {
+		st.GetToken(fmt.Sprintf("unknown%d", i))
+	}
+	c.Assert(st.Len(), Equals, 3)
+}
+
+func (s *S) TestStore_Cache_Evicted(c *C) {
+
+	bolt := openBoltStore()
+	st := tokenauth.NewCacheStore(bolt, 100, time.Minute)
+	defer st.Close()
+	other := tokenauth.NewCacheStore(bolt, 100, time.Minute)
+	bus := tokenauth.NewMemoryBus()
+	c.Assert(st.UseBus(bus), IsNil)
+	c.Assert(other.UseBus(bus), IsNil)
+
+	item := newAudience()
+	item.Policy = &tokenauth.Policy{MaxTokens: 1}
+	c.Assert(st.SaveAudience(item), IsNil)
+
+	t1 := &tokenauth.Token{ClientID: item.ID, Value: "evicted1", DeadLine: time.Now().Unix() + 100}
+	c.Assert(st.SaveToken(t1), IsNil)
+	newToken, _ := st.GetToken(t1.Value)
+	c.Assert(newToken, NotNil)
+	newToken, _ = other.GetToken(t1.Value)
+	c.Assert(newToken, NotNil)
+
+	// t1 is evicted by quota, cached t1 is revoked in both caches.
+	t2 := &tokenauth.Token{ClientID: item.ID, Value: "evicted2", DeadLine: time.Now().Unix() + 100}
+	evicted, err := st.SaveTokenEvicted(t2)
+	c.Assert(err, IsNil)
+	c.Assert(evicted, DeepEquals, []string{t1.Value})
+	newToken, err = st.GetToken(t1.Value)
+	c.Assert(err, IsNil)
+	c.Assert(newToken, IsNil)
+	newToken, err = other.GetToken(t1.Value)
+	c.Assert(err, IsNil)
+	c.Assert(newToken, IsNil)
+}
+
+// racingStore calls during once after read of token or audience.
+type racingStore struct {
+	tokenauth.TokenStore
+	during func()
+}
+
+func (s *racingStore) race() {
+	if d := s.during; d != nil {
+		s.during = nil
+		d()
+	}
+}
+
+func (s *racingStore) GetToken(tokenString string) (*tokenauth.Token, error) {
+	token, err := s.TokenStore.GetToken(tokenString)
+	s.race()
+	return token, err
+}
+
+func (s *racingStore) GetAudience(clientID string) (*tokenauth.Audience, error) {
+	audience, err := s.TokenStore.GetAudience(clientID)
+	s.race()
+	return audience, err
+}
+
+func (s *S) TestStore_Cache_InvalidateDuringRead(c *C) {
+
+	bolt := openBoltStore()
+	racing := &racingStore{TokenStore: bolt}
+	st := tokenauth.NewCacheStore(racing, 100, time.Minute)
+	defer st.Close()
+
+	item := newAudience()
+	c.Assert(st.SaveAudience(item), IsNil)
+	tokens := make([]*tokenauth.Token, 2)
+	for i := range tokens {
+		tokens[i] = &tokenauth.Token{ClientID: item.ID, Value: fmt.Sprintf("racing%d", i), DeadLine: time.Now().Unix() + 100}
+		c.Assert(st.SaveToken(tokens[i]), IsNil)
+	}
+
+	// Revoked during read, old token is not cached.
+	racing.during = func() { st.DeleteToken(tokens[0].Value) }
+	st.GetToken(tokens[0].Value)
+	newToken, err := st.GetToken(tokens[0].Value)
+	c.Assert(err, IsNil)
+	c.Assert(newToken, IsNil)
+
+	// Audience invalidated during read.
+	racing.during = func() {
+		bolt.DeleteToken(tokens[1].Value)
+		st.InvalidateAudience(item.ID)
+	}
+	st.GetToken(tokens[1].Value)
+	newToken, err = st.GetToken(tokens[1].Value)
+	c.Assert(err, IsNil)
+	c.Assert(newToken, IsNil)
+
+	racing.during = func() { st.DeleteAudience(item.ID) }
+	st.GetAudience(item.ID)
+	newItem, err := st.GetAudience(item.ID)
+	c.Assert(err, IsNil)
+	c.Assert(newItem, IsNil)
+
+	// Read without invalidation is cached.
+	st.GetToken("unknown")
+	c.Assert(st.Len(), Equals, 4)
+}
@@ -15,14 +15,48 @@ type Audience struct {
 	ID          string // Unique key for audience
 	Secret      string //audience secret string,can update.
 	TokenPeriod uint64 //token period ,unit: seconds.
+	Quota       *Quota `json:",omitempty"` // Optional issuance quota.
+	TokenPrefix string `json:",omitempty"` // Prefix of prefixed token format.
+
+	Policy         *Policy        `json:",omitempty"` // Optional token policy.
+	Status         AudienceStatus `json:",omitempty"` // Lifecycle status, default active.
+	SuspendedUntil int64          `json:",omitempty"` // End of suspension, time unix. 0 is never.
+	DeletedAt      int64          `json:",omitempty"` // Soft deletion time, time unix.
 }
 
 // Token Info
 type Token struct {
-	ClientID string // Audience.ID
-	SingleID string // Single Token ID
-	Value    string // Token string
-	DeadLine int64  // Token Expiration date, time unix.
+	ClientID string   // Audience.ID
+	SingleID string   // Single Token ID
+	Value    string   // Token string
+	DeadLine int64    // Token Expiration date, time unix.
+	Session  *Session `json:",omitempty"` // Optional session metadata.
+	Binding  *Binding `json:",omitempty"` // Optional binding to client attributes.
+	Scopes   []string `json:",omitempty"` // Scopes allowed by audience policy.
+	IssuedAt int64    `json:",omitempty"` // Issue time, time unix.
+}
+
+// Session metadata of token, captured at issuance.
+type Session struct {
+	Device    string `json:",omitempty"` // Device name, e.g: "iPhone".
+	ClientIP  string `json:",omitempty"`
+	UserAgent string `json:",omitempty"`
+	CreatedAt int64  // Issue time, time unix.
+	LastUsed  int64  // Last validation time, time unix. Updated with throttle.
+}
+
+// Option to set token fields before token saved.
+type TokenOption func(token *Token)
+
+// Returns option to attach session metadata to token.
+// CreatedAt is set to now if zero.
+func WithSession(session Session) TokenOption {
+	return func(token *Token) {
+		if session.CreatedAt == 0 {
+			session.CreatedAt = time.Now().Unix()
+		}
+		token.Session = &session
+	}
 }
 
 // Returns this token is expried.
@@ -34,7 +68,7 @@ func (t *Token) Expired() bool {
 	return time.Now().Unix() >= t.DeadLine
 }
 
-// Returns true if token clientID is empty and signleID is not empty.
+// Returns true if token signleID is not empty.
 func (t *Token) IsSingle() bool {
-	return len(t.ClientID) == 0 && len(t.SingleID) > 0
+	return len(t.SingleID) > 0
 }
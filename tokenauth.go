@@ -44,16 +44,23 @@
 // 	globalClient := tokenauth.NewAudienceNotStore("globalClient", secretFunc)
 // 	// New token
 // 	t1, err := tokenauth.NewToken(globalClient, tokenFunc)
+// 	// Fail with ErrTokenExists after MaxIssueAttempts.
 // 	t2, err := tokenauth.NewToken(globalClient, tokenFunc)
 package tokenauth
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"time"
 )
 
+// Max times of generating token string when new token string exists in store.
+var MaxIssueAttempts = 3
+
 // Token effective time,unti: seconds.
 // Defult is 2 Hour.
+// Fallback of audience policy AccessPeriod, copied to Audience.TokenPeriod by NewAudience.
 var TokenPeriod uint64 = 7200 //2hour
 
 //Global Token Store .
@@ -64,10 +71,10 @@ var Store TokenStore
 // New token and New Audience whill be saved to new store,after use new store.
 func ChangeTokenStore(newStore TokenStore) error {
 	if newStore == nil {
-		return errors.New("tokenauth: new store is nil.")
+		return fmt.Errorf("tokenauth: new store is nil: %w", ErrInvalidArgument)
 	}
 	if Store != nil {
-		if err := Store.Close(); err != nil {
+		if err := traceStore(context.Background(), Store, "Close", Store.Close); err != nil {
 			return err
 		}
 	}
@@ -93,76 +100,313 @@ type GenerateSecretString func(clientID string) (secretString string) //returns
 type GenerateTokenString func(audience *Audience) string //returns new token string
 
 // New audience and this audience will be saved to store.
-func NewAudience(name string, secretFunc GenerateSecretString) (*Audience, error) {
+// opts are applied to audience before saved.
+func NewAudience(name string, secretFunc GenerateSecretString, opts ...AudienceOption) (*Audience, error) {
+	return NewAudienceFrom(name, secretFunc, opts...)
+}
 
-	audience := NewAudienceNotStore(name, secretFunc)
+// Same as NewAudience, secret is created by provider.
+// Errors of ID generator, provider and store are returned.
+func NewAudienceFrom(name string, secrets SecretProvider, opts ...AudienceOption) (*Audience, error) {
+
+	audience, err := newAudience(name, secrets, opts...)
+	if err != nil {
+		return nil, err
+	}
 
 	//save to store
-	if err := Store.SaveAudience(audience); err != nil {
+	err = traceStore(context.Background(), Store, "SaveAudience", func() error { return Store.SaveAudience(audience) })
+	if err != nil {
 		return nil, err
 	} else {
+		emit(&Event{Type: EventAudienceSaved, Audience: audience, ClientID: audience.ID})
 		return audience, nil
 	}
 }
 
 // Returns a new audience info,not save to store.
-func NewAudienceNotStore(name string, secretFunc GenerateSecretString) *Audience {
+// Audience ID is generated by AudienceIDGenerator, panics if generation fails.
+func NewAudienceNotStore(name string, secretFunc GenerateSecretString, opts ...AudienceOption) *Audience {
 
+	audience, err := newAudience(name, secretFunc, opts...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return audience
+}
+
+func newAudience(name string, secrets SecretProvider, opts ...AudienceOption) (*Audience, error) {
+	id, err := AudienceIDGenerator.NewID()
+	if err != nil {
+		return nil, fmt.Errorf("tokenauth: generate audience id fail: %w", err)
+	}
 	audience := &Audience{
 		Name:        name,
-		ID:          NewObjectId().Hex(),
+		ID:          id,
 		TokenPeriod: TokenPeriod,
 	}
-	audience.Secret = secretFunc(audience.ID)
-	return audience
+	if audience.Secret, err = newSecret(secrets, audience.ID); err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		opt(audience)
+	}
+	return audience, nil
 }
 
 // New Token and this new token will be saved to store.
-func NewToken(a *Audience, tokenFunc GenerateTokenString) (*Token, error) {
-	token := &Token{
-		ClientID: a.ID,
-		Value:    tokenFunc(a),
+// opts are applied to token before saved.
+func NewToken(a *Audience, tokenFunc GenerateTokenString, opts ...TokenOption) (*Token, error) {
+	return NewTokenFrom(context.Background(), a, tokenFunc, opts...)
+}
+
+// Same as NewToken, trace span is child of span in ctx.
+func NewTokenContext(ctx context.Context, a *Audience, tokenFunc GenerateTokenString, opts ...TokenOption) (*Token, error) {
+	return NewTokenFrom(ctx, a, tokenFunc, opts...)
+}
+
+// Same as NewTokenContext, token string is created by provider.
+// Errors of provider and store are returned.
+func NewTokenFrom(ctx context.Context, a *Audience, tokens TokenProvider, opts ...TokenOption) (token *Token, err error) {
+	ctx, span := startSpan(ctx, "NewToken")
+	span.SetAttribute(AttrSingle, false)
+	defer func() { endSpan(span, err) }()
+
+	return issueToken(ctx, span, a, tokens, "", opts)
+}
+
+// New Sign Token and this new token will be saved to store.
+// Token keeps audience ID, live tokens of singleID are counted per audience.
+// opts are applied to token before saved.
+func NewSingleToken(singleID string, a *Audience, tokenFunc GenerateTokenString, opts ...TokenOption) (*Token, error) {
+	return NewSingleTokenFrom(context.Background(), singleID, a, tokenFunc, opts...)
+}
+
+// Same as NewSingleToken, trace span is child of span in ctx.
+func NewSingleTokenContext(ctx context.Context, singleID string, a *Audience, tokenFunc GenerateTokenString, opts ...TokenOption) (*Token, error) {
+	return NewSingleTokenFrom(ctx, singleID, a, tokenFunc, opts...)
+}
+
+// Same as NewSingleTokenContext, token string is created by provider.
+// Errors of provider and store are returned.
+func NewSingleTokenFrom(ctx context.Context, singleID string, a *Audience, tokens TokenProvider, opts ...TokenOption) (token *Token, err error) {
+	ctx, span := startSpan(ctx, "NewSingleToken")
+	span.SetAttribute(AttrSingle, true)
+	defer func() { endSpan(span, err) }()
+
+	return issueToken(ctx, span, a, tokens, singleID, opts)
+}
+
+// Generate token string, build and save token.
+// Policy of saved audience is applied, or policy of a if audience is not saved.
+// Generate again if token string exists in store, at most MaxIssueAttempts times.
+func issueToken(ctx context.Context, span Span, a *Audience, tokens TokenProvider, singleID string, opts []TokenOption) (*Token, error) {
+	var err error
+	policyAudience := a
+	if a != nil {
+		if err = a.CheckStatus(); err != nil {
+			return nil, err
+		}
+		saved, err := activeAudience(ctx, a.ID)
+		if err != nil {
+			return nil, err
+		}
+		if saved != nil {
+			policyAudience = saved
+		}
+		if format := policyAudience.policy().Format; format != nil {
+			tokens = format
+		}
 	}
-	if a.TokenPeriod == 0 {
-		token.DeadLine = 0
-	} else {
-		token.DeadLine = time.Now().Unix() + int64(a.TokenPeriod)
+	for attempt := 0; attempt < MaxIssueAttempts || attempt == 0; attempt++ {
+		var value string
+		if value, err = newTokenString(tokens, a); err != nil {
+			return nil, err
+		}
+		span.SetAttribute(AttrAudienceID, a.ID)
+
+		now := time.Now()
+		token := &Token{
+			ClientID: a.ID,
+			SingleID: singleID,
+			Value:    value,
+			DeadLine: policyAudience.deadLine(now),
+			IssuedAt: now.Unix(),
+		}
+		for _, opt := range opts {
+			opt(token)
+		}
+		if !policyAudience.policy().allowScopes(token.Scopes) {
+			return nil, ErrScopeNotAllowed
+		}
+		var evicted []string
+		err = traceStore(ctx, Store, "SaveToken", func() (err error) {
+			evicted, err = saveToken(token)
+			return
+		})
+		if err == nil {
+			for _, tokenString := range evicted {
+				emit(&Event{Type: EventTokenEvicted, TokenValue: tokenString, ClientID: token.ClientID})
+			}
+			emit(&Event{Type: EventTokenIssued, Token: token, TokenValue: token.Value, ClientID: token.ClientID})
+			return token, nil
+		}
+		if !errors.Is(err, ErrTokenExists) {
+			return nil, err
+		}
 	}
+	return nil, err
+}
 
-	if err := Store.SaveToken(token); err != nil {
-		return nil, err
-	} else {
-		return token, nil
+// Save token to store, returns tokens evicted if store is TokenEvicter.
+func saveToken(token *Token) ([]string, error) {
+	if evicter, ok := Store.(TokenEvicter); ok {
+		return evicter.SaveTokenEvicted(token)
 	}
+	return nil, Store.SaveToken(token)
 }
 
-// New Sign Token and this new token will be saved to store.
-func NewSingleToken(singleID string, a *Audience, tokenFunc GenerateTokenString) (*Token, error) {
-	token := &Token{
-		SingleID: singleID,
-		Value:    tokenFunc(a),
-		DeadLine: time.Now().Unix() + int64(a.TokenPeriod),
+// Delete token from store.
+func DeleteToken(tokenString string) error {
+	ctx := context.Background()
+	// Load token for event, failure does not affect deletion.
+	var token *Token
+	traceStore(ctx, Store, "GetToken", func() (err error) {
+		token, err = Store.GetToken(tokenString)
+		return
+	})
+	err := traceStore(ctx, Store, "DeleteToken", func() error { return Store.DeleteToken(tokenString) })
+	if err != nil {
+		return err
 	}
-	if err := Store.SaveToken(token); err != nil {
-		return nil, err
-	} else {
-		return token, nil
+	event := &Event{Type: EventTokenDeleted, Token: token, TokenValue: tokenString}
+	if token != nil {
+		event.ClientID = token.ClientID
 	}
+	emit(event)
+	return nil
+}
+
+// Delete audience and all tokens of audience from store.
+func DeleteAudience(clientID string) error {
+	err := traceStore(context.Background(), Store, "DeleteAudience", func() error { return Store.DeleteAudience(clientID) })
+	if err != nil {
+		return err
+	}
+	emit(&Event{Type: EventAudienceDeleted, ClientID: clientID})
+	return nil
+}
+
+// Returns tokens of singleID in audience clientID with session metadata.
+// Store must implement TokenLister.
+func SingleSessions(clientID, singleID string) ([]*Token, error) {
+	lister, ok := Store.(TokenLister)
+	if !ok {
+		return nil, fmt.Errorf("tokenauth: store can not list tokens: %w", ErrNotSupported)
+	}
+	return lister.TokensOfSingle(clientID, singleID)
+}
+
+// Returns tokens of audience with session metadata.
+// Store must implement TokenLister.
+func AudienceSessions(clientID string) ([]*Token, error) {
+	lister, ok := Store.(TokenLister)
+	if !ok {
+		return nil, fmt.Errorf("tokenauth: store can not list tokens: %w", ErrNotSupported)
+	}
+	return lister.TokensOfAudience(clientID)
 }
 
 // Returns Exist tokenstring or error.
 // If token is exist but  expired, then delete token and return TokenExpired error.
+// Bound token returns TokenBindingMismatch error, use ValidateBoundToken.
 func ValidateToken(tokenString string) (*Token, error) {
+	return ValidateTokenContext(context.Background(), tokenString)
+}
+
+// Same as ValidateToken, trace span is child of span in ctx.
+func ValidateTokenContext(ctx context.Context, tokenString string) (*Token, error) {
+	return validateWith(ctx, tokenString, nil)
+}
+
+// Validate token and call check if token is effective.
+// Nil check rejects bound token.
+// Returns nil token if check fail.
+func validateWith(ctx context.Context, tokenString string, check func(token *Token) error) (*Token, error) {
+	ctx, span := startSpan(ctx, "ValidateToken")
+
+	ipKey := ipLimitKey(clientIP(ctx))
+	var token *Token
+	err := limitAllow(limiter, ipKey)
+	if err == nil {
+		token, err = validateToken(ctx, tokenString)
+	}
+	if err == nil {
+		err = checkAudience(ctx, token)
+	}
+	var audience *Audience
+	if err == nil {
+		audience, err = activeAudience(ctx, token.ClientID)
+	}
+	if err == nil && !audience.policy().allowScopes(token.Scopes) {
+		err = ERR_TokenScopeNotAllowed
+	}
+	if err == nil {
+		err = limitAllow(audienceLimiter, audienceLimitKey(token.ClientID))
+	}
+	if check == nil {
+		check = checkUnbound
+	}
+	if err == nil {
+		err = check(token)
+	}
+	limitRecord(limiter, err, ipKey)
+	if err == nil {
+		slideToken(ctx, audience, token)
+	}
+	// Record token used, failure does not affect validation.
+	if toucher, ok := Store.(TokenToucher); ok && err == nil {
+		traceStore(ctx, Store, "TouchToken", func() error { return toucher.TouchToken(token.Value, time.Now().UnixNano()) })
+	}
+	if token != nil {
+		span.SetAttribute(AttrAudienceID, token.ClientID)
+		span.SetAttribute(AttrSingle, token.IsSingle())
+	}
+	endSpan(span, err)
+
+	if err != nil {
+		e := &Event{Type: EventTokenRejected, Token: token, TokenValue: tokenString, Err: err}
+		if token != nil {
+			e.ClientID = token.ClientID
+		}
+		emit(e)
+		if !errors.Is(err, ERR_TokenExpired) {
+			token = nil
+		}
+		return token, err
+	}
+	emit(&Event{Type: EventTokenValidated, Token: token, TokenValue: tokenString, ClientID: token.ClientID})
+	return token, nil
+}
+
+func validateToken(ctx context.Context, tokenString string) (*Token, error) {
 
 	if len(tokenString) == 0 {
 		return nil, ERR_TokenEmpty
 	}
+	if RequireTokenChecksum && !VerifyTokenChecksum(tokenString) {
+		return nil, ERR_InvalidateToken
+	}
 
 	var token *Token
 	var err error
 
 	// Get token info
-	if token, err = Store.GetToken(tokenString); err != nil {
+	err = traceStore(ctx, Store, "GetToken", func() (err error) {
+		token, err = Store.GetToken(tokenString)
+		return
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -173,9 +417,10 @@ func ValidateToken(tokenString string) (*Token, error) {
 
 	// Need delete token if token lose effectiveness
 	if token.Expired() {
-		if err = Store.DeleteToken(token.Value); err != nil {
+		if err = traceStore(ctx, Store, "DeleteToken", func() error { return Store.DeleteToken(token.Value) }); err != nil {
 			return nil, err
 		}
+		emit(&Event{Type: EventTokenExpired, Token: token, TokenValue: token.Value, ClientID: token.ClientID})
 		return token, ERR_TokenExpired
 	}
 
@@ -186,4 +431,12 @@ var (
 	ERR_InvalidateToken = ValidationError{Code: "40001", Msg: "Invalid token"}
 	ERR_TokenEmpty      = ValidationError{Code: "41001", Msg: "Token is empty"}
 	ERR_TokenExpired    = ValidationError{Code: "42001", Msg: "Token is expired"}
+
+	ERR_TokenBindingMismatch    = ValidationError{Code: "43001", Msg: "Token binding mismatch"}
+	ERR_DPoPProofInvalid        = ValidationError{Code: "43002", Msg: "Invalid DPoP proof"}
+	ERR_TokenAudienceNotAllowed = ValidationError{Code: "43003", Msg: "Token audience not allowed"}
+	ERR_TokenScopeNotAllowed    = ValidationError{Code: "43004", Msg: "Token scope not allowed"}
+
+	ERR_InvalidateAudienceSecret = ValidationError{Code: "40002", Msg: "Invalid audience secret"}
+	ERR_RateLimited              = ValidationError{Code: "44001", Msg: "Too many requests"}
 )
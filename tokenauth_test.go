@@ -5,6 +5,7 @@
 package tokenauth_test
 
 import (
+	"errors"
 	"fmt"
 	"github.com/ysqi/tokenauth"
 	. "gopkg.in/check.v1"
@@ -83,7 +84,7 @@ func (s *S) TestToken_Valid(c *C) {
 
 	tokenauth.TokenPeriod = 2 //2s
 	audience, _ := tokenauth.NewAudience("forTest", NewSecret)
-	token, _ = tokenauth.NewToken(audience, GenerateTokenString)
+	token, _ = tokenauth.NewToken(audience, keyPorvider.GenerateTokenString)
 
 	newToken, err := tokenauth.ValidateToken(token.Value)
 	c.Assert(err, IsNil)
@@ -96,6 +97,47 @@ func (s *S) TestToken_Valid(c *C) {
 	c.Assert(newToken.Expired(), Equals, true)
 }
 
+func (s *S) TestToken_NewSingle_NotStore(c *C) {
+
+	d := &tokenauth.DefaultProvider{}
+	globalClient := tokenauth.NewAudienceNotStore("globalClient", d.GenerateSecretString)
+	token, err := tokenauth.NewSingleToken("singleID", globalClient, d.GenerateTokenString)
+	c.Assert(err, IsNil)
+	c.Assert(token.ClientID, Equals, globalClient.ID)
+	_, err = tokenauth.ValidateToken(token.Value)
+	c.Assert(err, IsNil)
+
+	// Multi token needs saved audience.
+	_, err = tokenauth.NewToken(globalClient, d.GenerateTokenString)
+	c.Assert(errors.Is(err, tokenauth.ErrAudienceNotFound), Equals, true)
+}
+
+func (s *S) TestToken_New_Exists(c *C) {
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret)
+	sameTokens := func(a *tokenauth.Audience) string { return "TestForSameTokenString" }
+	_, err := tokenauth.NewToken(audience, sameTokens)
+	c.Assert(err, IsNil)
+	_, err = tokenauth.NewToken(audience, sameTokens)
+	c.Assert(errors.Is(err, tokenauth.ErrTokenExists), Equals, true)
+	_, err = tokenauth.NewSingleToken("single", audience, sameTokens)
+	c.Assert(errors.Is(err, tokenauth.ErrTokenExists), Equals, true)
+
+	// Generate again after collision.
+	calls := 0
+	retryTokens := func(a *tokenauth.Audience) string {
+		calls++
+		if calls < tokenauth.MaxIssueAttempts {
+			return "TestForSameTokenString"
+		}
+		return fmt.Sprintf("TestForRetryTokenString%d", calls)
+	}
+	token, err := tokenauth.NewToken(audience, retryTokens)
+	c.Assert(err, IsNil)
+	c.Assert(calls, Equals, tokenauth.MaxIssueAttempts)
+	c.Assert(token.Value, Equals, fmt.Sprintf("TestForRetryTokenString%d", calls))
+}
+
 // tempfile returns a temporary file path.
 func tempfile() string {
 	f, _ := ioutil.TempFile("", "bolt-store-")
@@ -113,3 +155,13 @@ func openBoltStore() *tokenauth.BoltDBFileStore {
 	}
 	return st
 }
+
+// Use st as global store, returned func restores old store and closes st.
+func useStore(st tokenauth.TokenStore) (restore func()) {
+	old := tokenauth.Store
+	tokenauth.Store = st
+	return func() {
+		tokenauth.Store = old
+		st.Close()
+	}
+}
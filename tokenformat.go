@@ -0,0 +1,101 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"fmt"
+	"hash/crc32"
+	"regexp"
+)
+
+// Prefixed token format: <prefix>_<random><checksum>.
+// Random part is TokenRandomLength base62 chars, checksum is crc32 of
+// "<prefix>_<random>" encoded as 6 base62 chars.
+// Leaked tokens can be found by secret scanners with TokenRegexp,
+// typos are rejected by checksum without store access.
+const (
+	TokenRandomLength  = 30
+	DefaultTokenPrefix = "tka"
+	checksumLength     = 6
+)
+
+// Matches prefixed tokens in text, checksum is not verified.
+// Prefix is 1-16 lowercase letters or digits starting with a letter.
+var TokenRegexp = regexp.MustCompile(`\b[a-z][a-z0-9]{0,15}_[0-9A-Za-z]{36}\b`)
+
+var (
+	tokenPrefixRegexp = regexp.MustCompile(`^[a-z][a-z0-9]{0,15}$`)
+	tokenFormatRegexp = regexp.MustCompile(`^[a-z][a-z0-9]{0,15}_[0-9A-Za-z]{36}$`)
+)
+
+// Reject tokens with invalid checksum in ValidateToken before store access.
+// Set true only if all audiences issue prefixed tokens.
+var RequireTokenChecksum = false
+
+// Returns true if prefix is valid token prefix.
+func ValidTokenPrefix(prefix string) bool {
+	return tokenPrefixRegexp.MatchString(prefix)
+}
+
+// Returns option to set token prefix of audience.
+func WithTokenPrefix(prefix string) AudienceOption {
+	return func(audience *Audience) {
+		audience.TokenPrefix = prefix
+	}
+}
+
+func tokenChecksum(body string) string {
+	sum := crc32.ChecksumIEEE([]byte(body))
+	var b [checksumLength]byte
+	for i := checksumLength - 1; i >= 0; i-- {
+		b[i] = alphanum[sum%62]
+		sum /= 62
+	}
+	return string(b[:])
+}
+
+// Returns new prefixed token string.
+func NewPrefixedToken(prefix string) (string, error) {
+	if !ValidTokenPrefix(prefix) {
+		return "", fmt.Errorf("tokenauth: invalid token prefix %q: %w", prefix, ErrInvalidArgument)
+	}
+	random, err := randomString(alphanum, TokenRandomLength)
+	if err != nil {
+		return "", err
+	}
+	body := prefix + "_" + random
+	return body + tokenChecksum(body), nil
+}
+
+// GenerateTokenString of prefixed token, prefix is audience TokenPrefix
+// or DefaultTokenPrefix if empty. Panics if prefix is invalid,
+// use PrefixedTokens to get the error.
+func PrefixedTokenString(audience *Audience) string {
+	token, err := PrefixedTokens.NewTokenString(audience)
+	if err != nil {
+		panic(err.Error())
+	}
+	return token
+}
+
+// Returns true if token is prefixed token with valid checksum.
+func VerifyTokenChecksum(token string) bool {
+	if !tokenFormatRegexp.MatchString(token) {
+		return false
+	}
+	n := len(token) - checksumLength
+	return token[n:] == tokenChecksum(token[:n])
+}
+
+// Returns prefixed tokens with valid checksum in text, for secret scanning.
+func FindTokens(text string) []string {
+	var tokens []string
+	for _, token := range TokenRegexp.FindAllString(text, -1) {
+		if VerifyTokenChecksum(token) {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth_test
+
+import (
+	"github.com/ysqi/tokenauth"
+	. "gopkg.in/check.v1"
+	"strings"
+)
+
+// Counts GetToken calls of wrapped store.
+type countingStore struct {
+	tokenauth.TokenStore
+	gets int
+}
+
+func (s *countingStore) GetToken(tokenString string) (*tokenauth.Token, error) {
+	s.gets++
+	return s.TokenStore.GetToken(tokenString)
+}
+
+func (s *S) TestTokenFormat_New(c *C) {
+
+	token, err := tokenauth.NewPrefixedToken("acme")
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(token, "acme_"), Equals, true)
+	c.Assert(len(token), Equals, len("acme_")+36)
+	c.Assert(tokenauth.VerifyTokenChecksum(token), Equals, true)
+
+	// Typo in random part or checksum.
+	typo := []byte(token)
+	typo[10] ^= 1
+	c.Assert(tokenauth.VerifyTokenChecksum(string(typo)), Equals, false)
+	c.Assert(tokenauth.VerifyTokenChecksum(token[:len(token)-1]+"!"), Equals, false)
+	c.Assert(tokenauth.VerifyTokenChecksum("x"+token), Equals, false)
+
+	for _, prefix := range []string{"", "Acme", "1acme", "a_b", "abcdefghijklmnopq"} {
+		_, err = tokenauth.NewPrefixedToken(prefix)
+		c.Check(err, NotNil, Commentf("%q", prefix))
+	}
+}
+
+func (s *S) TestTokenFormat_Find(c *C) {
+
+	a, _ := tokenauth.NewPrefixedToken("acme")
+	b, _ := tokenauth.NewPrefixedToken("tka")
+	fake := "acme_" + strings.Repeat("A", 36)
+	text := "export TOKEN=" + a + "\n" + `{"token":"` + b + `"} ` + fake
+
+	c.Assert(tokenauth.TokenRegexp.FindAllString(text, -1), DeepEquals, []string{a, b, fake})
+	c.Assert(tokenauth.FindTokens(text), DeepEquals, []string{a, b})
+}
+
+func (s *S) TestTokenFormat_Validate(c *C) {
+
+	audience, _ := tokenauth.NewAudience("forTest", NewSecret, tokenauth.WithTokenPrefix("acme"))
+	token, err := tokenauth.NewToken(audience, tokenauth.PrefixedTokenString)
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(token.Value, "acme_"), Equals, true)
+
+	tokenauth.RequireTokenChecksum = true
+	store := &countingStore{TokenStore: tokenauth.Store}
+	tokenauth.Store = store
+	defer func() {
+		tokenauth.RequireTokenChecksum = false
+		tokenauth.Store = store.TokenStore
+	}()
+
+	_, err = tokenauth.ValidateToken(token.Value)
+	c.Assert(err, IsNil)
+	c.Assert(store.gets, Equals, 1)
+
+	typo := []byte(token.Value)
+	typo[len(typo)-1] ^= 1
+	_, err = tokenauth.ValidateToken(string(typo))
+	c.Assert(err, Equals, tokenauth.ERR_InvalidateToken)
+	_, err = tokenauth.ValidateToken("guess")
+	c.Assert(err, Equals, tokenauth.ERR_InvalidateToken)
+	c.Assert(store.gets, Equals, 1)
+}
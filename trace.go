@@ -0,0 +1,107 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tokenauth
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// Span attribute keys.
+const (
+	AttrAudienceID = "tokenauth.audience_id"
+	AttrSingle     = "tokenauth.single"
+	AttrOutcome    = "tokenauth.outcome"
+	AttrStore      = "tokenauth.store"
+)
+
+// Span outcome if no error.
+const OutcomeOK = "ok"
+
+// Tracer starts spans around token operations and store methods.
+// See package tracing for OpenTelemetry tracer.
+type Tracer interface {
+
+	// Start span named name as child of span in ctx.
+	// Returns ctx contains new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span of one operation.
+type Span interface {
+
+	// Set attribute, value is string, bool or int64.
+	SetAttribute(key string, value interface{})
+
+	// End span, err is nil if operation succeeded.
+	End(err error)
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+
+func (noopSpan) End(err error) {}
+
+// Tracer used by all token operations, default does nothing.
+var tracer Tracer = noopTracer{}
+
+// Use t to trace token operations and store methods.
+// Use no-op tracer if t is nil.
+func SetTracer(t Tracer) {
+	if t == nil {
+		t = noopTracer{}
+	}
+	tracer = t
+}
+
+// Start span of token operation.
+func startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return tracer.Start(ctx, "tokenauth."+name)
+}
+
+// Set outcome code and end span.
+func endSpan(span Span, err error) {
+	outcome := OutcomeOK
+	if v, ok := AsValidationError(err); ok {
+		outcome = v.Code
+	} else if err != nil {
+		outcome = "error"
+	}
+	span.SetAttribute(AttrOutcome, outcome)
+	span.End(err)
+}
+
+// Call store method fn in span named by store method.
+func traceStore(ctx context.Context, store TokenStore, method string, fn func() error) error {
+	_, span := startSpan(ctx, "store."+method)
+	span.SetAttribute(AttrStore, storeAdapterName(store))
+	err := fn()
+	endSpan(span, err)
+	return err
+}
+
+// Returns registered adapter name of store, or type name if not registered.
+func storeAdapterName(store TokenStore) string {
+	if store == nil || !reflect.TypeOf(store).Comparable() {
+		return fmt.Sprintf("%T", store)
+	}
+	for name, adapter := range adapters {
+		if adapter == store {
+			return name
+		}
+	}
+	return fmt.Sprintf("%T", store)
+}
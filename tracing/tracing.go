@@ -0,0 +1,90 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package tracing emits OpenTelemetry spans for tokenauth token operations
+// and store methods.
+//
+//	tokenauth.SetTracer(tracing.NewTracer(tracing.WithTracerProvider(tp)))
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"github.com/ysqi/tokenauth"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Instrumentation name of tracer.
+const InstrumentationName = "github.com/ysqi/tokenauth"
+
+type config struct {
+	provider trace.TracerProvider
+}
+
+// Tracer option.
+type Option func(c *config)
+
+// Use provider to create tracer.
+// Default is no-op provider.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.provider = provider
+	}
+}
+
+// Tracer implements tokenauth.Tracer with OpenTelemetry.
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// New tracer.
+func NewTracer(opts ...Option) *Tracer {
+	c := &config{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.provider == nil {
+		c.provider = noop.NewTracerProvider()
+	}
+	return &Tracer{tracer: c.provider.Tracer(InstrumentationName)}
+}
+
+// Start span.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, tokenauth.Span) {
+	ctx, span := t.tracer.Start(ctx, name)
+	return ctx, &Span{span: span}
+}
+
+// Span wraps OpenTelemetry span.
+type Span struct {
+	span trace.Span
+}
+
+// Set attribute.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		s.span.SetAttributes(attribute.String(key, v))
+	case bool:
+		s.span.SetAttributes(attribute.Bool(key, v))
+	case int:
+		s.span.SetAttributes(attribute.Int(key, v))
+	case int64:
+		s.span.SetAttributes(attribute.Int64(key, v))
+	default:
+		s.span.SetAttributes(attribute.String(key, fmt.Sprint(v)))
+	}
+}
+
+// End span, record err and set error status if err is not nil.
+func (s *Span) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 Author YuShuangqi. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tracing_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/ysqi/tokenauth"
+	"github.com/ysqi/tokenauth/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type S struct{}
+
+var _ = Suite(&S{})
+
+func openBoltStore() *tokenauth.BoltDBFileStore {
+	f, _ := ioutil.TempFile("", "bolt-store-")
+	f.Close()
+	os.Remove(f.Name())
+	st := tokenauth.NewBoltDBFileStore()
+	if err := st.Open(fmt.Sprintf(`{"path":"%s"}`, f.Name())); err != nil {
+		panic(err)
+	}
+	return st
+}
+
+func attrs(span sdktrace.ReadOnlySpan) map[attribute.Key]string {
+	m := make(map[attribute.Key]string)
+	for _, kv := range span.Attributes() {
+		m[kv.Key] = kv.Value.Emit()
+	}
+	return m
+}
+
+func (s *S) TestTracer(c *C) {
+
+	tokenauth.ChangeTokenStore(openBoltStore())
+	defer tokenauth.Store.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tokenauth.SetTracer(tracing.NewTracer(tracing.WithTracerProvider(provider)))
+	defer tokenauth.SetTracer(nil)
+
+	d := &tokenauth.DefaultProvider{}
+	audience, _ := tokenauth.NewAudience("forTest", d.GenerateSecretString)
+	token, err := tokenauth.NewSingleToken("single", audience, d.GenerateTokenString)
+	c.Assert(err, IsNil)
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	tokenauth.ValidateTokenContext(ctx, token.Value)
+	tokenauth.ValidateTokenContext(ctx, "guess")
+	parent.End()
+
+	spans := recorder.Ended()
+	names := make([]string, len(spans))
+	for i, span := range spans {
+		names[i] = span.Name()
+	}
+	c.Assert(names, DeepEquals, []string{
+		"tokenauth.store.SaveAudience",
+		"tokenauth.store.GetAudience",
+		"tokenauth.store.SaveToken",
+		"tokenauth.NewSingleToken",
+		"tokenauth.store.GetToken",
+		"tokenauth.store.GetAudience",
+		"tokenauth.store.TouchToken",
+		"tokenauth.ValidateToken",
+		"tokenauth.store.GetToken",
+		"tokenauth.ValidateToken",
+		"request",
+	})
+
+	issue := attrs(spans[3])
+	c.Assert(issue[tokenauth.AttrAudienceID], Equals, audience.ID)
+	c.Assert(issue[tokenauth.AttrSingle], Equals, "true")
+	c.Assert(issue[tokenauth.AttrOutcome], Equals, tokenauth.OutcomeOK)
+
+	c.Assert(attrs(spans[4])[tokenauth.AttrStore], Equals, "*tokenauth.BoltDBFileStore")
+	c.Assert(spans[4].Parent().SpanID(), Equals, spans[7].SpanContext().SpanID())
+	c.Assert(spans[7].Parent().SpanID(), Equals, spans[10].SpanContext().SpanID())
+
+	c.Assert(attrs(spans[9])[tokenauth.AttrOutcome], Equals, tokenauth.ERR_InvalidateToken.Code)
+}
+
+func (s *S) TestTracer_Noop(c *C) {
+
+	t := tracing.NewTracer()
+	ctx, span := t.Start(context.Background(), "noop")
+	c.Assert(ctx, NotNil)
+	span.SetAttribute("key", "value")
+	span.End(nil)
+}
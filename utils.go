@@ -7,21 +7,51 @@ package tokenauth
 import (
 	"crypto/rand"
 	"encoding/base32"
+	"fmt"
 )
 
 const (
 	alphanum = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
 )
 
-// Returns s random string
+// Returns s random string of alphanum chars without bias,
+// encoded to base32 if encodeToBase32.
+// Panics if crypto random source fails, use NewRandomString to get the error.
 func GenerateRandomString(size int, encodeToBase32 bool) string {
-	var bytes = make([]byte, size)
-	rand.Read(bytes)
-	for i, b := range bytes {
-		bytes[i] = alphanum[b%byte(len(alphanum))]
+	str, err := NewRandomString(size)
+	if err != nil {
+		panic(fmt.Sprintf("tokenauth: generate random string fail, %s", err.Error()))
 	}
 	if encodeToBase32 {
-		return base32.StdEncoding.EncodeToString(bytes)
+		return base32.StdEncoding.EncodeToString([]byte(str))
 	}
-	return string(bytes)
+	return str
+}
+
+// Returns random string of size alphanum chars.
+func NewRandomString(size int) (string, error) {
+	return randomString(alphanum, size)
+}
+
+// Returns random string of n chars of alphabet, every char has equal probability.
+// alphabet must have at most 256 chars.
+func randomString(alphabet string, n int) (string, error) {
+	// Reject bytes >= max to avoid modulo bias.
+	max := 256 - 256%len(alphabet)
+	out := make([]byte, 0, n)
+	buf := make([]byte, n+n/4+1)
+	for len(out) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < max {
+				out = append(out, alphabet[int(b)%len(alphabet)])
+				if len(out) == n {
+					break
+				}
+			}
+		}
+	}
+	return string(out), nil
 }
@@ -34,3 +34,17 @@ func (s *S) TestUtls_RandomStringLength(c *C) {
 	}
 
 }
+
+func (s *S) TestUtls_RandomStringUnbiased(c *C) {
+
+	str, err := tokenauth.NewRandomString(62 * 1000)
+	c.Assert(err, IsNil)
+	counts := make(map[rune]int)
+	for _, r := range str {
+		counts[r]++
+	}
+	c.Assert(len(counts), Equals, 62)
+	for r, n := range counts {
+		c.Check(n > 800 && n < 1200, Equals, true, Commentf("%c appears %d times", r, n))
+	}
+}